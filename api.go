@@ -0,0 +1,766 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/BrianWill/WorkoutTracker/store"
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// The /api/v1 resource API, used by the Android client. Every response body
+// is JSON. Failures have the body {"error": {"code": ..., "message": ...}}.
+//
+// Clients authenticate with the token returned by POST /api/v1/login, sent
+// as "Authorization: Bearer <token>". The browser's session cookie works too.
+// When a token is rotated, the replacement comes back in the X-Session-Token header.
+//
+// Weights are decimal numbers in the unit of the user, kg or lb, both ways.
+
+const sessionTokenHeader = "X-Session-Token"
+
+// dateFormat is the layout of dates in query parameters.
+const dateFormat = "2006-01-02"
+
+// Error codes of the API.
+const (
+	codeBadRequest   = "bad_request"
+	codeUnauthorized = "unauthorized"
+	codeNotFound     = "not_found"
+	codeInvalid      = "invalid"
+	codeConflict     = "conflict"
+	codeInternal     = "internal"
+)
+
+// apiError aborts the request with a JSON error body.
+func apiError(c *gin.Context, status int, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{"error": gin.H{"code": code, "message": message}})
+}
+
+// apiStoreError aborts the request with the status matching an error from the store.
+func apiStoreError(c *gin.Context, err error) {
+	switch err {
+	case store.ErrNotFound:
+		apiError(c, http.StatusNotFound, codeNotFound, err.Error())
+	case store.ErrBadWorkoutName, store.ErrBadTimes, store.ErrFinishTemplate, store.ErrTemplateSession,
+		store.ErrNegativeSet, store.ErrTemplateActuals, store.ErrTemplateLive, store.ErrBadUnit, store.ErrBadPlateIncrement,
+		store.ErrBadSetType, store.ErrRepTarget, store.ErrDropParent:
+		apiError(c, http.StatusUnprocessableEntity, codeInvalid, err.Error())
+	case store.ErrExerciseInUse, store.ErrUserExists, store.ErrWorkoutFinished, store.ErrWorkoutNotEmpty,
+		store.ErrSetStarted, store.ErrSetNotStarted, store.ErrSetCompleted, store.ErrSetInProgress,
+		store.ErrNoRestTimer, store.ErrHasDropSets:
+		apiError(c, http.StatusConflict, codeConflict, err.Error())
+	case store.ErrBadLogin, store.ErrNoSession:
+		apiError(c, http.StatusUnauthorized, codeUnauthorized, err.Error())
+	default:
+		apiError(c, http.StatusInternalServerError, codeInternal, err.Error())
+	}
+}
+
+// bindJSON decodes the request body, answering 400 if it isn't valid JSON.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	if err := c.ShouldBindJSON(obj); err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid JSON body. "+err.Error())
+		return false
+	}
+	return true
+}
+
+// bearerToken returns the session token of the Authorization header, if any.
+func bearerToken(c *gin.Context) string {
+	const prefix = "Bearer "
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(header, prefix)
+}
+
+// requireAPIUser is the API's counterpart of requireUser: it answers 401
+// instead of redirecting to the login page.
+func requireAPIUser(db sqlbuilder.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var user store.UserDB
+		var err error
+		if token := bearerToken(c); token != "" {
+			var rotated string
+			user, rotated, err = store.SessionUser(db, token)
+			if rotated != "" {
+				c.Header(sessionTokenHeader, rotated)
+			}
+		} else {
+			user, err = sessionUser(c, db)
+		}
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.Set(userKey, user)
+		c.Next()
+	}
+}
+
+// apiPath holds the IDs of a nested resource, e.g. /workouts/1/exercises/2/sets/3.
+type apiPath struct {
+	workout, exercise, set uint64
+}
+
+// resolvePath parses the IDs in the path and checks that each record belongs
+// to the user and to the record before it in the path. On failure the
+// request is aborted and ok is false.
+func resolvePath(c *gin.Context, db store.Conn) (p apiPath, ok bool) {
+	userID := currentUser(c).ID
+	var err error
+	if p.workout, err = idParam(c, "id"); err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid workout ID.")
+		return p, false
+	}
+	if _, err = store.UserWorkout(db, userID, p.workout); err != nil {
+		apiStoreError(c, err)
+		return p, false
+	}
+	if c.Param("exerciseID") == "" {
+		return p, true
+	}
+	if p.exercise, err = idParam(c, "exerciseID"); err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid exercise ID.")
+		return p, false
+	}
+	exercise, err := store.UserExercise(db, userID, p.exercise)
+	if err == nil && exercise.Workout != p.workout {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		apiStoreError(c, err)
+		return p, false
+	}
+	if c.Param("setID") == "" {
+		return p, true
+	}
+	if p.set, err = idParam(c, "setID"); err != nil {
+		apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid set ID.")
+		return p, false
+	}
+	set, err := store.UserSet(db, userID, p.set)
+	if err == nil && set.Exercise != p.exercise {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		apiStoreError(c, err)
+		return p, false
+	}
+	return p, true
+}
+
+func workoutURL(id uint64) string {
+	return "/api/v1/workouts/" + strconv.FormatUint(id, 10)
+}
+
+func exerciseURL(workoutID, id uint64) string {
+	return workoutURL(workoutID) + "/exercises/" + strconv.FormatUint(id, 10)
+}
+
+func setURL(workoutID, exerciseID, id uint64) string {
+	return exerciseURL(workoutID, exerciseID) + "/sets/" + strconv.FormatUint(id, 10)
+}
+
+// created answers 201 with the new resource and its location.
+func created(c *gin.Context, location string, obj interface{}) {
+	c.Header("Location", location)
+	c.JSON(http.StatusCreated, obj)
+}
+
+func registerAPI(router *gin.Engine, db sqlbuilder.Database, live *hub) {
+	v1 := router.Group("/api/v1")
+	api := v1.Group("/", requireAPIUser(db))
+
+	v1.POST("/login", func(c *gin.Context) {
+		var req struct {
+			Name     string `json:"name"`
+			Password string `json:"password"`
+		}
+		if !bindJSON(c, &req) {
+			return
+		}
+		user, err := store.Authenticate(db, req.Name, req.Password)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		token, err := store.NewSession(db, user.ID, c.Request.UserAgent())
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"token": token, "user": user})
+	})
+
+	api.POST("/logout", func(c *gin.Context) {
+		token := bearerToken(c)
+		if token == "" {
+			token, _ = c.Cookie(sessionCookie)
+		}
+		if err := store.RevokeSession(db, token); err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	api.GET("/user", func(c *gin.Context) {
+		c.JSON(http.StatusOK, currentUser(c))
+	})
+
+	// the user's preferences: {"unit": "kg"|"lb", "plateIncrement": in the unit}
+	api.PATCH("/user", func(c *gin.Context) {
+		var patch store.PreferencesPatch
+		if !bindJSON(c, &patch) {
+			return
+		}
+		user, err := store.UpdatePreferences(db, currentUser(c).ID, patch)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, user)
+	})
+
+	api.GET("/catalog", func(c *gin.Context) {
+		catalog, err := store.Catalog(db)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		for i, def := range catalog {
+			catalog[i] = def.ToUnit(currentUser(c).Unit)
+		}
+		c.JSON(http.StatusOK, catalog)
+	})
+
+	// the user's personal records for a catalog exercise, oldest first
+	api.GET("/catalog/:id/records", func(c *gin.Context) {
+		definitionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid exercise ID.")
+			return
+		}
+		records, err := store.Records(db, currentUser(c).ID, definitionID)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		for i, r := range records {
+			records[i] = r.ToUnit(currentUser(c).Unit)
+		}
+		c.JSON(http.StatusOK, records)
+	})
+
+	// every session of a catalog exercise, latest first, with its best and latest set
+	api.GET("/catalog/:id/history", func(c *gin.Context) {
+		definitionID, err := strconv.ParseUint(c.Param("id"), 10, 64)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, codeBadRequest, "Invalid exercise ID.")
+			return
+		}
+		history, err := store.History(db, currentUser(c).ID, definitionID)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, history.ToUnit(currentUser(c).Unit))
+	})
+
+	// ?template=true lists only templates, ?template=false only sessions
+	api.GET("/workouts", func(c *gin.Context) {
+		cond := up.Cond{"user": currentUser(c).ID}
+		switch c.Query("template") {
+		case "":
+		case "true":
+			cond["startTime"] = 0
+		case "false":
+			cond["startTime >"] = 0
+		default:
+			apiError(c, http.StatusBadRequest, codeBadRequest, "template must be true or false.")
+			return
+		}
+		workouts := []store.WorkoutDB{}
+		err := db.Collection("workouts").Find(cond).OrderBy("startTime", "id").All(&workouts)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, workouts)
+	})
+
+	api.POST("/workouts", func(c *gin.Context) {
+		var workout store.WorkoutDB
+		if !bindJSON(c, &workout) {
+			return
+		}
+		workout, err := store.AddWorkout(db, currentUser(c).ID, workout)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		created(c, workoutURL(workout.ID), workout)
+	})
+
+	api.GET("/workouts/:id", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		workout, err := store.LoadWorkout(db, currentUser(c).ID, p.workout)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, workout.ToUnit(currentUser(c).Unit))
+	})
+
+	api.PATCH("/workouts/:id", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		var patch store.WorkoutPatch
+		if !bindJSON(c, &patch) {
+			return
+		}
+		workout, err := store.UpdateWorkout(db, currentUser(c).ID, p.workout, patch)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventWorkoutUpdated, workout)
+		c.JSON(http.StatusOK, workout)
+	})
+
+	// the workout's exercises and sets go with it (ON DELETE CASCADE)
+	api.DELETE("/workouts/:id", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		if err := db.Collection("workouts").Find(p.workout).Delete(); err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	})
+
+	api.GET("/workouts/:id/exercises", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		exercises, err := store.UserExercises(db, currentUser(c).ID, p.workout)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		for i, e := range exercises {
+			exercises[i] = e.ToUnit(currentUser(c).Unit)
+		}
+		c.JSON(http.StatusOK, exercises)
+	})
+
+	// the body names a catalog exercise: {"definition": 3, "notes": "..."}
+	api.POST("/workouts/:id/exercises", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		var req store.ExerciseDB
+		if !bindJSON(c, &req) {
+			return
+		}
+		exercise, err := store.AddExercise(db, currentUser(c).ID, p.workout, req.Definition, req.Notes)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventExerciseAdded, exercise)
+		created(c, exerciseURL(p.workout, exercise.ID), exercise.ToUnit(currentUser(c).Unit))
+	})
+
+	api.GET("/workouts/:id/exercises/:exerciseID", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		exercises, err := store.UserExercises(db, currentUser(c).ID, p.workout)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		for _, e := range exercises {
+			if e.ID == p.exercise {
+				c.JSON(http.StatusOK, e.ToUnit(currentUser(c).Unit))
+				return
+			}
+		}
+		apiStoreError(c, store.ErrNotFound)
+	})
+
+	api.PATCH("/workouts/:id/exercises/:exerciseID", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		var patch store.ExercisePatch
+		if !bindJSON(c, &patch) {
+			return
+		}
+		exercise, err := store.UpdateExercise(db, currentUser(c).ID, p.exercise, patch)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventExerciseUpdated, exercise)
+		c.JSON(http.StatusOK, exercise)
+	})
+
+	api.DELETE("/workouts/:id/exercises/:exerciseID", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		if _, err := store.RemoveExercise(db, currentUser(c).ID, p.exercise); err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventExerciseRemoved, gin.H{"id": p.exercise})
+		c.Status(http.StatusNoContent)
+	})
+
+	api.GET("/workouts/:id/exercises/:exerciseID/sets", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		sets, err := store.UserSets(db, currentUser(c).ID, p.exercise)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		for i, s := range sets {
+			sets[i] = s.ToUnit(currentUser(c).Unit)
+		}
+		c.JSON(http.StatusOK, sets)
+	})
+
+	api.POST("/workouts/:id/exercises/:exerciseID/sets", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		var set store.SetDB
+		if !bindJSON(c, &set) {
+			return
+		}
+		unit := currentUser(c).Unit
+		set, err := store.AddSet(db, currentUser(c).ID, p.exercise, set.FromUnit(unit))
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventSetAdded, set)
+		created(c, setURL(p.workout, p.exercise, set.ID), set.ToUnit(unit))
+	})
+
+	api.GET("/workouts/:id/exercises/:exerciseID/sets/:setID", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		set, err := store.UserSet(db, currentUser(c).ID, p.set)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, set.ToUnit(currentUser(c).Unit))
+	})
+
+	api.PATCH("/workouts/:id/exercises/:exerciseID/sets/:setID", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		var patch store.SetPatch
+		if !bindJSON(c, &patch) {
+			return
+		}
+		unit := currentUser(c).Unit
+		var set store.SetDB
+		err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			var err error
+			set, err = store.UpdateSet(tx, currentUser(c).ID, p.set, patch.FromUnit(unit))
+			return err
+		})
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventSetUpdated, set)
+		c.JSON(http.StatusOK, set.ToUnit(unit))
+	})
+
+	api.DELETE("/workouts/:id/exercises/:exerciseID/sets/:setID", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		if _, err := store.RemoveSet(db, currentUser(c).ID, p.set); err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventSetRemoved, gin.H{"id": p.set})
+		c.Status(http.StatusNoContent)
+	})
+
+	api.POST("/workouts/:id/start", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		workout, err := store.StartWorkout(db, currentUser(c).ID, p.workout)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventWorkoutStarted, workout)
+		c.JSON(http.StatusOK, workout)
+	})
+
+	api.POST("/workouts/:id/finish", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		workout, err := store.FinishWorkout(db, currentUser(c).ID, p.workout)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventWorkoutFinished, workout)
+		c.JSON(http.StatusOK, workout)
+	})
+
+	api.POST("/workouts/:id/exercises/:exerciseID/sets/:setID/start", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		set, err := store.StartSet(db, currentUser(c).ID, p.set)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publishStarted(db, currentUser(c).ID, set)
+		c.JSON(http.StatusOK, set.ToUnit(currentUser(c).Unit))
+	})
+
+	api.POST("/workouts/:id/exercises/:exerciseID/sets/:setID/complete", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		set, err := store.CompleteSet(db, currentUser(c).ID, p.set)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publishCompleted(db, currentUser(c).ID, set)
+		c.JSON(http.StatusOK, set.ToUnit(currentUser(c).Unit))
+	})
+
+	api.GET("/workouts/:id/rest", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		timer, err := store.RestTimer(db, currentUser(c).ID, p.workout)
+		if err == store.ErrNoRestTimer {
+			err = store.ErrNotFound
+		}
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, timer)
+	})
+
+	// body: {"ms": milliseconds to add, negative to shorten}
+	api.POST("/workouts/:id/rest/extend", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		var body struct {
+			Ms *int64 `json:"ms"`
+		}
+		if !bindJSON(c, &body) {
+			return
+		}
+		if body.Ms == nil {
+			apiError(c, http.StatusBadRequest, codeBadRequest, "ms is required.")
+			return
+		}
+		timer, err := store.ExtendRest(db, currentUser(c).ID, p.workout, *body.Ms)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventRestExtended, timer)
+		c.JSON(http.StatusOK, timer)
+	})
+
+	api.POST("/workouts/:id/rest/skip", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		timer, err := store.SkipRest(db, currentUser(c).ID, p.workout)
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		live.publish(p.workout, eventRestEnded, timer)
+		c.JSON(http.StatusOK, timer)
+	})
+
+	// ?from=YYYY-MM-DD&to=YYYY-MM-DD (inclusive, UTC; default the last 12 weeks)
+	// &by=workout|day|week|month (default week) &per=total|exercise|muscle (default total)
+	api.GET("/analytics/:metric", func(c *gin.Context) {
+		q := store.AnalyticsQuery{
+			Metric: c.Param("metric"),
+			By:     c.DefaultQuery("by", store.ByWeek),
+			Per:    c.DefaultQuery("per", store.PerTotal),
+			Unit:   currentUser(c).Unit,
+		}
+		today := time.Now().UTC().Truncate(24 * time.Hour)
+		q.To, q.From = today.AddDate(0, 0, 1), today.AddDate(0, 0, -7*12+1)
+		for _, d := range []struct {
+			param string
+			date  *time.Time
+			shift int
+		}{
+			{"from", &q.From, 0},
+			{"to", &q.To, 1}, // through the end of the day
+		} {
+			if s := c.Query(d.param); s != "" {
+				t, err := time.Parse(dateFormat, s)
+				if err != nil {
+					apiError(c, http.StatusBadRequest, codeBadRequest, d.param+" must be a date like 2006-01-02.")
+					return
+				}
+				*d.date = t.AddDate(0, 0, d.shift)
+			}
+		}
+		if s := c.Query("exercise"); s != "" {
+			id, err := strconv.ParseUint(s, 10, 64)
+			if err != nil {
+				apiError(c, http.StatusBadRequest, codeBadRequest, "exercise must be a catalog exercise ID.")
+				return
+			}
+			q.Definition = id
+		}
+		series, err := store.Analytics(db, currentUser(c).ID, q)
+		if err == store.ErrBadQuery {
+			apiError(c, http.StatusBadRequest, codeBadRequest, err.Error())
+			return
+		}
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"metric": q.Metric,
+			"from":   q.From.Format(dateFormat),
+			"to":     q.To.AddDate(0, 0, -1).Format(dateFormat),
+			"by":     q.By,
+			"per":    q.Per,
+			"unit":   q.Unit,
+			"series": series,
+		})
+	})
+
+	// every workout of the user as ?format=json (default), nested like GET
+	// /workouts/:id, or ?format=csv, one row per set
+	api.GET("/export", func(c *gin.Context) {
+		format := c.DefaultQuery("format", "json")
+		if _, ok := exports[format]; !ok {
+			apiError(c, http.StatusBadRequest, codeBadRequest, "format must be csv or json.")
+			return
+		}
+		streamExport(c, db, format)
+	})
+
+	// a full backup of the user, see store.Backup
+	api.GET("/backup", func(c *gin.Context) {
+		streamBackup(c, db)
+	})
+
+	// reads a CSV export of Strong, Hevy or FitNotes into sessions; see store.Import
+	api.POST("/import", func(c *gin.Context) {
+		var req struct {
+			CSV        string            `json:"csv"`
+			Format     string            `json:"format"`     // empty to tell from the header
+			SourceUnit string            `json:"sourceUnit"` // of an export that doesn't say; the user's if empty
+			TimeZone   string            `json:"timeZone"`   // of the export's times, e.g. "Europe/Berlin"; UTC if empty
+			Exercises  map[string]uint64 `json:"exercises"`  // catalog exercises of names that match none
+			DryRun     bool              `json:"dryRun"`
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+		if !bindJSON(c, &req) {
+			return
+		}
+		loc, err := time.LoadLocation(req.TimeZone)
+		if err != nil {
+			apiError(c, http.StatusBadRequest, codeBadRequest, "timeZone must be a time zone like Europe/Berlin.")
+			return
+		}
+		result, err := store.Import(db, currentUser(c).ID, strings.NewReader(req.CSV), store.ImportOptions{
+			Format:     req.Format,
+			SourceUnit: req.SourceUnit,
+			Location:   loc,
+			Exercises:  req.Exercises,
+			DryRun:     req.DryRun,
+		})
+		if _, ok := err.(*store.ImportError); ok || err == store.ErrBadUnit {
+			apiError(c, http.StatusUnprocessableEntity, codeInvalid, err.Error())
+			return
+		}
+		if err == store.ErrUnmatchedExercises {
+			apiError(c, http.StatusUnprocessableEntity, codeInvalid, err.Error()+": "+strings.Join(result.Unmatched, ", "))
+			return
+		}
+		if err != nil {
+			apiStoreError(c, err)
+			return
+		}
+		for i, w := range result.Workouts {
+			result.Workouts[i] = w.ToUnit(currentUser(c).Unit)
+		}
+		if req.DryRun {
+			c.JSON(http.StatusOK, result)
+			return
+		}
+		c.JSON(http.StatusCreated, result)
+	})
+
+	// streams the changes to the workout as Server-Sent Events, see events.go
+	api.GET("/workouts/:id/events", func(c *gin.Context) {
+		p, ok := resolvePath(c, db)
+		if !ok {
+			return
+		}
+		live.stream(c, p.workout)
+	})
+}
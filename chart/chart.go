@@ -0,0 +1,201 @@
+// Package chart draws simple line and bar charts as SVG, so pages can show
+// progress without a charting library in the browser.
+package chart
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Size of a chart in pixels, and of the margins holding the title and axes.
+const (
+	Width  = 640
+	Height = 240
+
+	marginLeft   = 50
+	marginRight  = 15
+	marginTop    = 30
+	marginBottom = 40
+)
+
+// palette colors the series of a line chart in turn.
+var palette = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b"}
+
+// Point is a value at a time.
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Series is a named line of a line chart. Points must be in time order.
+type Series struct {
+	Name   string
+	Points []Point
+}
+
+// Bar is one bar of a bar chart.
+type Bar struct {
+	Label string
+	Value float64
+}
+
+// canvas writes the SVG of a chart, remembering the first write error.
+type canvas struct {
+	w   io.Writer
+	err error
+}
+
+func (c *canvas) printf(format string, args ...interface{}) {
+	if c.err == nil {
+		_, c.err = fmt.Fprintf(c.w, format, args...)
+	}
+}
+
+func (c *canvas) text(x, y float64, anchor, class, s string) {
+	c.printf(`<text x="%.1f" y="%.1f" text-anchor="%s" class="%s">%s</text>`+"\n", x, y, anchor, class, html.EscapeString(s))
+}
+
+func (c *canvas) start(title string) {
+	c.printf(`<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif">`+"\n", Width, Height, Width, Height)
+	c.printf(`<style>.title{font-size:14px;font-weight:bold}.axis{font-size:10px;fill:#555}.legend{font-size:11px}.empty{font-size:12px;fill:#888}</style>` + "\n")
+	c.printf(`<rect width="100%%" height="100%%" fill="white"/>` + "\n")
+	c.text(Width/2, 18, "middle", "title", title)
+}
+
+func (c *canvas) end() {
+	c.printf("</svg>\n")
+}
+
+// empty draws the frame of a chart that has nothing to show yet.
+func (c *canvas) empty(title string) error {
+	c.start(title)
+	c.text(Width/2, Height/2, "middle", "empty", "No data yet")
+	c.end()
+	return c.err
+}
+
+// yAxis draws the horizontal grid lines and value labels of the range and
+// returns the function placing a value on the y axis.
+func (c *canvas) yAxis(lo, hi float64) func(float64) float64 {
+	step := niceStep((hi - lo) / 4)
+	lo = math.Floor(lo/step) * step
+	hi = math.Ceil(hi/step) * step
+	if hi == lo {
+		hi = lo + step
+	}
+	bottom, top := float64(Height-marginBottom), float64(marginTop)
+	y := func(v float64) float64 {
+		return bottom - (v-lo)/(hi-lo)*(bottom-top)
+	}
+	for v := lo; v <= hi+step/2; v += step {
+		c.printf(`<line x1="%d" y1="%.1f" x2="%d" y2="%.1f" stroke="#ddd"/>`+"\n", marginLeft, y(v), Width-marginRight, y(v))
+		c.text(marginLeft-5, y(v)+3, "end", "axis", formatValue(v))
+	}
+	return y
+}
+
+// niceStep rounds a step between grid lines up to 1, 2 or 5 times a power of ten.
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if raw <= m*magnitude {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatValue(v float64) string {
+	if math.Abs(v) >= 10000 {
+		return strconv.FormatFloat(v/1000, 'f', -1, 64) + "k"
+	}
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// LineChart draws a line chart of the series over time.
+func LineChart(w io.Writer, title string, series []Series) error {
+	c := &canvas{w: w}
+	var first, last time.Time
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, p := range s.Points {
+			if first.IsZero() || p.Time.Before(first) {
+				first = p.Time
+			}
+			if p.Time.After(last) {
+				last = p.Time
+			}
+			lo, hi = math.Min(lo, p.Value), math.Max(hi, p.Value)
+		}
+	}
+	if first.IsZero() {
+		return c.empty(title)
+	}
+	c.start(title)
+	y := c.yAxis(lo, hi)
+	left, right := float64(marginLeft+10), float64(Width-marginRight-10)
+	span := last.Sub(first).Seconds()
+	x := func(t time.Time) float64 {
+		if span == 0 {
+			return (left + right) / 2
+		}
+		return left + t.Sub(first).Seconds()/span*(right-left)
+	}
+	c.text(x(first), Height-marginBottom+15, "start", "axis", first.Format("Jan 2 2006"))
+	if span > 0 {
+		c.text(x(last), Height-marginBottom+15, "end", "axis", last.Format("Jan 2 2006"))
+	}
+	for i, s := range series {
+		color := palette[i%len(palette)]
+		points := make([]string, len(s.Points))
+		for j, p := range s.Points {
+			points[j] = fmt.Sprintf("%.1f,%.1f", x(p.Time), y(p.Value))
+		}
+		c.printf(`<polyline points="%s" fill="none" stroke="%s" stroke-width="2"/>`+"\n", strings.Join(points, " "), color)
+		for _, p := range s.Points {
+			c.printf(`<circle cx="%.1f" cy="%.1f" r="3" fill="%s"><title>%s: %s</title></circle>`+"\n",
+				x(p.Time), y(p.Value), color, p.Time.Format("Jan 2 2006"), formatValue(p.Value))
+		}
+		if len(series) > 1 {
+			lx := float64(marginLeft + i*120)
+			c.printf(`<rect x="%.1f" y="%d" width="10" height="10" fill="%s"/>`+"\n", lx, Height-16, color)
+			c.text(lx+14, Height-7, "start", "legend", s.Name)
+		}
+	}
+	c.end()
+	return c.err
+}
+
+// BarChart draws a bar chart with the bars in the order given. Values start at zero.
+func BarChart(w io.Writer, title string, bars []Bar) error {
+	c := &canvas{w: w}
+	if len(bars) == 0 {
+		return c.empty(title)
+	}
+	hi := 0.0
+	for _, b := range bars {
+		hi = math.Max(hi, b.Value)
+	}
+	c.start(title)
+	y := c.yAxis(0, hi)
+	slot := float64(Width-marginLeft-marginRight) / float64(len(bars))
+	labelEvery := int(math.Ceil(float64(len(bars)) * 60 / float64(Width-marginLeft-marginRight))) // labels about 60px apart
+	for i, b := range bars {
+		x := float64(marginLeft) + float64(i)*slot
+		c.printf(`<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`+"\n",
+			x+slot*0.15, y(b.Value), slot*0.7, y(0)-y(b.Value), palette[0], html.EscapeString(b.Label), formatValue(b.Value))
+		if i%labelEvery == 0 {
+			c.text(x+slot/2, Height-marginBottom+15, "middle", "axis", b.Label)
+		}
+	}
+	c.end()
+	return c.err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	"github.com/BrianWill/WorkoutTracker/chart"
+	"github.com/BrianWill/WorkoutTracker/store"
+	"github.com/gin-gonic/gin"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// chartWeeks is how many weeks back the charts of the home page go.
+const chartWeeks = 12
+
+// registerCharts adds the routes of the SVG progress charts, drawn from the
+// analytics of the current user.
+func registerCharts(authed *gin.RouterGroup, db sqlbuilder.Database) {
+	authed.GET("/charts/volume.svg", func(c *gin.Context) {
+		weeklyChart(c, db, store.MetricTonnage, "Weekly volume (reps × "+currentUser(c).Unit+")")
+	})
+
+	authed.GET("/charts/frequency.svg", func(c *gin.Context) {
+		weeklyChart(c, db, store.MetricSessions, "Sessions per week")
+	})
+
+	// estimated one-rep max of a catalog exercise over every session of it
+	authed.GET("/exercise/:id/1rm.svg", func(c *gin.Context) {
+		definitionID, err := idParam(c, "id")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid exercise ID.")
+			return
+		}
+		series, err := store.Analytics(db, currentUser(c).ID, store.AnalyticsQuery{
+			Metric:     store.MetricOneRepMax,
+			From:       time.Unix(0, 0),
+			To:         time.Now().Add(time.Hour),
+			By:         store.ByWorkout,
+			Per:        store.PerTotal,
+			Definition: definitionID,
+			Unit:       currentUser(c).Unit,
+		})
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error reading analytics. "+err.Error())
+			return
+		}
+		lines := make([]chart.Series, len(series))
+		for i, s := range series {
+			lines[i].Name = s.Name
+			for _, p := range s.Points {
+				lines[i].Points = append(lines[i].Points, chart.Point{Time: time.Unix(p.Time, 0), Value: p.Value})
+			}
+		}
+		var buf bytes.Buffer
+		if err := chart.LineChart(&buf, "Estimated one-rep max ("+currentUser(c).Unit+")", lines); err != nil {
+			c.String(http.StatusInternalServerError, "Error drawing chart. "+err.Error())
+			return
+		}
+		svg(c, buf.Bytes())
+	})
+}
+
+// weeklyChart draws a bar chart of the metric per week over the last
+// chartWeeks weeks, including the weeks without any sessions.
+func weeklyChart(c *gin.Context, db sqlbuilder.Database, metric, title string) {
+	now := time.Now().UTC()
+	thisWeek := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	thisWeek = thisWeek.AddDate(0, 0, -(int(thisWeek.Weekday())+6)%7)
+	from := thisWeek.AddDate(0, 0, -7*(chartWeeks-1))
+	series, err := store.Analytics(db, currentUser(c).ID, store.AnalyticsQuery{
+		Metric: metric,
+		From:   from,
+		To:     thisWeek.AddDate(0, 0, 7),
+		By:     store.ByWeek,
+		Per:    store.PerTotal,
+		Unit:   currentUser(c).Unit,
+	})
+	if err != nil {
+		c.String(http.StatusInternalServerError, "Error reading analytics. "+err.Error())
+		return
+	}
+	values := map[int64]float64{}
+	for _, s := range series {
+		for _, p := range s.Points {
+			values[p.Time] = p.Value
+		}
+	}
+	bars := make([]chart.Bar, chartWeeks)
+	for i := range bars {
+		week := from.AddDate(0, 0, 7*i)
+		bars[i] = chart.Bar{Label: week.Format("Jan 2"), Value: values[week.Unix()]}
+	}
+	var buf bytes.Buffer
+	if err := chart.BarChart(&buf, title, bars); err != nil {
+		c.String(http.StatusInternalServerError, "Error drawing chart. "+err.Error())
+		return
+	}
+	svg(c, buf.Bytes())
+}
+
+// svg responds with a drawn chart. Charts change with every logged set, so
+// they aren't cached.
+func svg(c *gin.Context, data []byte) {
+	c.Header("Cache-Control", "no-cache")
+	c.Data(http.StatusOK, "image/svg+xml", data)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+
+	"github.com/BrianWill/WorkoutTracker/store"
+)
+
+// Kinds of workout events, sent as the SSE event name. Weights are published
+// as stored and sent in the unit of each watcher.
+const (
+	eventWorkoutUpdated  = "workout-updated"  // data: store.WorkoutDB
+	eventWorkoutStarted  = "workout-started"  // data: store.WorkoutDB
+	eventWorkoutFinished = "workout-finished" // data: store.WorkoutDB
+	eventExerciseAdded   = "exercise-added"   // data: store.Exercise or store.ExerciseDB
+	eventExerciseUpdated = "exercise-updated" // data: store.ExerciseDB
+	eventExerciseRemoved = "exercise-removed" // data: {"id": exercise ID}
+	eventSetAdded        = "set-added"        // data: store.SetDB
+	eventSetUpdated      = "set-updated"      // data: store.SetDB
+	eventSetRemoved      = "set-removed"      // data: {"id": set ID}
+	eventSetStarted      = "set-started"      // data: store.SetDB
+	eventSetCompleted    = "set-completed"    // data: store.SetDB
+	eventRestStarted     = "rest-started"     // data: store.RestTimerDB
+	eventRestExtended    = "rest-extended"    // data: store.RestTimerDB
+	eventRestEnded       = "rest-ended"       // data: store.RestTimerDB, sent on skipping as well as starting the next set
+)
+
+// keepAliveInterval is how often an idle event stream is sent a ping, so
+// proxies (Heroku's router drops connections idle for 55s) keep it open.
+const keepAliveInterval = 20 * time.Second
+
+// subscriberBuffer is how many events a slow subscriber may fall behind
+// before further events are dropped for it.
+const subscriberBuffer = 32
+
+type workoutEvent struct {
+	id   uint64
+	kind string
+	data interface{}
+}
+
+// hub fans out the changes of each workout to the devices watching it, so a
+// phone logging sets and a screen showing the session stay in sync. It lives
+// in memory, so it only connects devices served by the same process.
+type hub struct {
+	mu     sync.Mutex
+	lastID uint64
+	subs   map[uint64]map[chan workoutEvent]struct{} // by workout ID
+}
+
+func newHub() *hub {
+	return &hub{subs: map[uint64]map[chan workoutEvent]struct{}{}}
+}
+
+// subscribe returns a channel receiving the events of the workout. The
+// returned function must be called once the subscriber is done.
+func (h *hub) subscribe(workoutID uint64) (<-chan workoutEvent, func()) {
+	ch := make(chan workoutEvent, subscriberBuffer)
+	h.mu.Lock()
+	if h.subs[workoutID] == nil {
+		h.subs[workoutID] = map[chan workoutEvent]struct{}{}
+	}
+	h.subs[workoutID][ch] = struct{}{}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subs[workoutID], ch)
+		if len(h.subs[workoutID]) == 0 {
+			delete(h.subs, workoutID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+// publish sends an event to everyone watching the workout. It never blocks:
+// a subscriber whose buffer is full misses the event.
+func (h *hub) publish(workoutID uint64, kind string, data interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lastID++
+	e := workoutEvent{id: h.lastID, kind: kind, data: data}
+	for ch := range h.subs[workoutID] {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// publishSet sends an event about a set to the watchers of the workout it is part of.
+func (h *hub) publishSet(db store.Conn, userID uint64, kind string, set store.SetDB) {
+	exercise, err := store.UserExercise(db, userID, set.Exercise)
+	if err != nil {
+		return
+	}
+	h.publish(exercise.Workout, kind, set)
+}
+
+// publishStarted sends the start of a set along with the end of the rest
+// before it, if one was running.
+func (h *hub) publishStarted(db store.Conn, userID uint64, set store.SetDB) {
+	exercise, err := store.UserExercise(db, userID, set.Exercise)
+	if err != nil {
+		return
+	}
+	if timer, err := store.RestTimer(db, userID, exercise.Workout); err == nil && timer.EndedAt == set.StartedAt {
+		h.publish(exercise.Workout, eventRestEnded, timer)
+	}
+	h.publish(exercise.Workout, eventSetStarted, set)
+}
+
+// publishCompleted sends the completion of a set along with the start of
+// the rest after it.
+func (h *hub) publishCompleted(db store.Conn, userID uint64, set store.SetDB) {
+	exercise, err := store.UserExercise(db, userID, set.Exercise)
+	if err != nil {
+		return
+	}
+	h.publish(exercise.Workout, eventSetCompleted, set)
+	if timer, err := store.RestTimer(db, userID, exercise.Workout); err == nil {
+		h.publish(exercise.Workout, eventRestStarted, timer)
+	}
+}
+
+// inUnit returns event data with its weights converted to the unit.
+func inUnit(data interface{}, unit string) interface{} {
+	switch d := data.(type) {
+	case store.SetDB:
+		return d.ToUnit(unit)
+	case store.Exercise:
+		return d.ToUnit(unit)
+	}
+	return data
+}
+
+// stream sends the events of the workout to the client as Server-Sent
+// Events until the client goes away.
+func (h *hub) stream(c *gin.Context, workoutID uint64) {
+	unit := currentUser(c).Unit
+	events, done := h.subscribe(workoutID)
+	defer done()
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Render(-1, sse.Event{Event: "hello", Retry: 3000, Data: gin.H{"workout": workoutID}})
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case e := <-events:
+			c.Render(-1, sse.Event{Id: strconv.FormatUint(e.id, 10), Event: e.kind, Data: inUnit(e.data, unit)})
+		case <-keepAlive.C:
+			c.Render(-1, sse.Event{Event: "ping", Data: ""})
+		case <-c.Request.Context().Done():
+			return false
+		}
+		return true
+	})
+}
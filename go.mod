@@ -1,18 +1,38 @@
 module github.com/BrianWill/WorkoutTracker
 
+go 1.27.1
+
 require (
-	github.com/gin-contrib/sse v0.0.0-20190125020943-a7658810eb74 // indirect
+	github.com/gin-contrib/sse v0.0.0-20190125020943-a7658810eb74
 	github.com/gin-gonic/gin v1.3.1-0.20190204012700-5acf6601170b
 	github.com/gopherjs/gopherjs v0.0.0-20181103185306-d547d1d9531e
 	github.com/heroku/x v0.0.0-20181102215100-85e5aa5e6aa1
 	github.com/lib/pq v1.0.0
 	github.com/mattn/go-sqlite3 v1.10.0
-	github.com/satori/go.uuid v1.2.0
+	golang.org/x/crypto v0.0.0-20190211182817-74369b46fc67
+	honnef.co/go/js/dom v0.0.0-20181202134054-9dbdcd412bde
+	honnef.co/go/js/xhr v0.0.0-20150307031022-00e3346113ae
+	upper.io/db.v3 v3.5.7+incompatible
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/json-iterator/go v1.1.5 // indirect
+	github.com/mattn/go-isatty v0.0.4 // indirect
+	github.com/modern-go/concurrent v0.0.0-20180306012644-bacd9c7ef1dd // indirect
+	github.com/modern-go/reflect2 v1.0.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+	github.com/stretchr/testify v1.3.0 // indirect
+	github.com/ugorji/go v1.1.2 // indirect
 	github.com/ugorji/go/codec v0.0.0-20190204201341-e444a5086c43 // indirect
 	golang.org/x/net v0.0.0-20190206173232-65e2d4e15006 // indirect
+	golang.org/x/sync v0.0.0-20181221193216-37e7f081c4d4 // indirect
 	golang.org/x/sys v0.0.0-20190209173611-3b5209105503 // indirect
-	honnef.co/go/js/dom v0.0.0-20181202134054-9dbdcd412bde
+	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
+	gopkg.in/go-playground/assert.v1 v1.2.1 // indirect
+	gopkg.in/go-playground/validator.v8 v8.18.2 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
 	honnef.co/go/js/util v0.0.0-20150216223935-96b8dd9d1621 // indirect
-	honnef.co/go/js/xhr v0.0.0-20150307031022-00e3346113ae
-	upper.io/db.v3 v3.5.7+incompatible
 )
@@ -6,6 +6,8 @@ JSON marshal/unmarshal functions from https://github.com/johanbrandhorst/gopherj
 */
 
 import (
+	"strconv"
+
 	"github.com/gopherjs/gopherjs/js"
 	"honnef.co/go/js/dom"
 	"honnef.co/go/js/xhr"
@@ -154,10 +156,14 @@ func reload() {
 func pageAdminUsers() {
 	button := doc.GetElementByID("add_button").(*dom.HTMLButtonElement)
 	userNameText := doc.GetElementByID("user_name_text").(*dom.HTMLInputElement)
+	userPasswordText := doc.GetElementByID("user_password_text").(*dom.HTMLInputElement)
 	userList := doc.GetElementByID("user_list")
 
 	button.AddEventListener("click", false, func(evt dom.Event) {
-		sendStr("/json/addUser", userNameText.Value)
+		sendJSON("/json/addUser", map[string]interface{}{
+			"name":     userNameText.Value,
+			"password": userPasswordText.Value,
+		})
 	})
 
 	userList.AddEventListener("click", false, func(evt dom.Event) {
@@ -171,19 +177,36 @@ func pageAdminExercises() {
 	button := doc.GetElementByID("add_button").(*dom.HTMLButtonElement)
 	exerciseNameText := doc.GetElementByID("exercise_name_text").(*dom.HTMLInputElement)
 	exerciseNotesText := doc.GetElementByID("exercise_notes_text").(*dom.HTMLTextAreaElement)
+	exerciseMusclesText := doc.GetElementByID("exercise_muscles_text").(*dom.HTMLInputElement)
+	exerciseEquipmentText := doc.GetElementByID("exercise_equipment_text").(*dom.HTMLInputElement)
+	exerciseSetsText := doc.GetElementByID("exercise_sets_text").(*dom.HTMLInputElement)
+	exerciseTypeSelect := doc.GetElementByID("exercise_type_select").(*dom.HTMLSelectElement)
+	exerciseRepsText := doc.GetElementByID("exercise_reps_text").(*dom.HTMLInputElement)
+	exerciseDurationText := doc.GetElementByID("exercise_duration_text").(*dom.HTMLInputElement)
+	exerciseRestText := doc.GetElementByID("exercise_rest_text").(*dom.HTMLInputElement)
 	exerciseList := doc.GetElementByID("exercise_list")
 
 	button.AddEventListener("click", false, func(evt dom.Event) {
-		sendJSON("/json/addExercise", map[string]interface{}{
-			"name":  exerciseNameText.Value,
-			"notes": exerciseNotesText.Value,
+		sendJSON("/json/addExerciseDefinition", map[string]interface{}{
+			"name":            exerciseNameText.Value,
+			"notes":           exerciseNotesText.Value,
+			"muscleGroups":    exerciseMusclesText.Value,
+			"equipment":       exerciseEquipmentText.Value,
+			"defaultSets":     exerciseSetsText.ValueAsNumber,
+			"defaultType":     exerciseTypeSelect.Value,
+			"defaultReps":     exerciseRepsText.ValueAsNumber,
+			"defaultDuration": exerciseDurationText.ValueAsNumber * 1000, // milliseconds
+			"defaultRest":     exerciseRestText.ValueAsNumber * 1000,     // milliseconds
 		})
 	})
 
 	exerciseList.AddEventListener("click", false, func(evt dom.Event) {
 		exerciseID := evt.Target().GetAttribute("exerciseID")
+		if exerciseID == "" { // not a removal link
+			return
+		}
 		evt.PreventDefault()
-		sendStr("/json/removeExercise", exerciseID)
+		sendStr("/json/removeExerciseDefinition", exerciseID)
 	})
 }
 
@@ -207,11 +230,17 @@ func pageAdminWorkouts() {
 
 func pageAdminWorkoutEdit() {
 	button := doc.GetElementByID("edit_button").(*dom.HTMLButtonElement)
+	workoutID := doc.GetElementByID("workout_id").(*dom.HTMLInputElement)
 	workoutNameText := doc.GetElementByID("workout_name_text").(*dom.HTMLInputElement)
+	workoutStartText := doc.GetElementByID("workout_start_text").(*dom.HTMLInputElement)
+	workoutEndText := doc.GetElementByID("workout_end_text").(*dom.HTMLInputElement)
 
 	button.AddEventListener("click", false, func(evt dom.Event) {
 		sendJSON("/json/updateWorkout", map[string]interface{}{
-			"name": workoutNameText.Value,
+			"id":        workoutID.ValueAsNumber,
+			"name":      workoutNameText.Value,
+			"startTime": workoutStartText.ValueAsNumber,
+			"endTime":   workoutEndText.ValueAsNumber,
 		})
 	})
 
@@ -220,14 +249,159 @@ func pageAdminWorkoutEdit() {
 
 func pageAdminSetEdit() {
 	button := doc.GetElementByID("edit_button").(*dom.HTMLButtonElement)
+	setID := doc.GetElementByID("set_id").(*dom.HTMLInputElement)
+	setType := doc.GetElementByID("set_type_select").(*dom.HTMLSelectElement)
+
+	// JSON field of the set patch -> input holding its value
+	fields := map[string]*dom.HTMLInputElement{}
+	for field, id := range map[string]string{
+		"order":            "set_order_text",
+		"parent":           "set_parent_text",
+		"reps":             "set_reps_text",
+		"weight":           "set_weight_text",
+		"duration":         "set_duration_text",
+		"rest":             "set_rest_text",
+		"repsExpected":     "set_reps_expected_text",
+		"weightExpected":   "set_weight_expected_text",
+		"durationExpected": "set_duration_expected_text",
+		"restExpected":     "set_rest_expected_text",
+	} {
+		fields[field] = doc.GetElementByID(id).(*dom.HTMLInputElement)
+	}
 
-	// todo: get set info from text fields
+	button.AddEventListener("click", false, func(evt dom.Event) {
+		patch := map[string]interface{}{
+			"id":   setID.ValueAsNumber,
+			"type": setType.Value,
+		}
+		for field, input := range fields {
+			if input.Value != "" { // blank fields are left as they are
+				patch[field] = input.ValueAsNumber
+			}
+		}
+		sendJSON("/json/updateSet", patch)
+	})
+}
+
+func pageAdminExerciseEdit() {
+	button := doc.GetElementByID("edit_button").(*dom.HTMLButtonElement)
+	exerciseID := doc.GetElementByID("exercise_id").(*dom.HTMLInputElement)
+	progression := doc.GetElementByID("exercise_progression_select").(*dom.HTMLSelectElement)
+
+	// JSON field of the progression patch -> input holding its value
+	fields := map[string]*dom.HTMLInputElement{}
+	for field, id := range map[string]string{
+		"increment":     "exercise_increment_text",
+		"repsMax":       "exercise_reps_max_text",
+		"deloadAfter":   "exercise_deload_after_text",
+		"deloadPercent": "exercise_deload_percent_text",
+	} {
+		fields[field] = doc.GetElementByID(id).(*dom.HTMLInputElement)
+	}
 
 	button.AddEventListener("click", false, func(evt dom.Event) {
-		sendJSON("/json/updateSet", map[string]interface{}{
-			"reps": 0,
-		})
+		patch := map[string]interface{}{
+			"id":          exerciseID.ValueAsNumber,
+			"progression": progression.Value,
+		}
+		for field, input := range fields {
+			if input.Value != "" { // blank fields are left as they are
+				patch[field] = input.ValueAsNumber
+			}
+		}
+		sendJSON("/json/updateExerciseProgression", patch)
+	})
+}
+
+// seconds formats milliseconds like the "seconds" template function.
+func seconds(ms int) string {
+	return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64) + "s"
+}
+
+// countdown formats the milliseconds left as m:ss, rounded up. It is
+// negative once overrun.
+func countdown(ms int) string {
+	sign := ""
+	if ms < 0 {
+		sign, ms = "-", -ms
+	}
+	s := (ms + 999) / 1000
+	secs := strconv.Itoa(s % 60)
+	if len(secs) == 1 {
+		secs = "0" + secs
+	}
+	return sign + strconv.Itoa(s/60) + ":" + secs
+}
+
+// restCountdown ticks down the rest timer shown on the workout page. The
+// server renders the time remaining, so the device's clock doesn't matter.
+func restCountdown() {
+	el := doc.GetElementByID("rest_remaining")
+	if el == nil {
+		return
+	}
+	remaining, err := strconv.Atoi(el.GetAttribute("data-remaining"))
+	if err != nil {
+		return
+	}
+	ends := js.Global.Get("Date").Call("now").Int() + remaining
+	js.Global.Call("setInterval", func() {
+		el.SetTextContent(countdown(ends - js.Global.Get("Date").Call("now").Int()))
+	}, 250)
+}
+
+// pageWorkout keeps the workout page in sync with changes made on other
+// devices, via the workout's event stream. Edits of a set are applied in
+// place. Anything that changes the layout of the page reloads it.
+func pageWorkout(workoutID int) {
+	source := js.Global.Get("EventSource").New("/workout/" + strconv.Itoa(workoutID) + "/events")
+	liveStatus := doc.GetElementByID("live_status")
+	restCountdown()
+
+	source.Call("addEventListener", "set-updated", func(evt *js.Object) {
+		set, err := Unmarshal(evt.Get("data").String())
+		if err != nil {
+			println(err)
+			return
+		}
+		id := "set_" + set.Get("id").String()
+		row := doc.GetElementByID(id)
+		if row == nil || row.GetAttribute("data-type") != set.Get("type").String() {
+			reload()
+			return
+		}
+		repsExpected := set.Get("repsExpected").String()
+		if t := set.Get("type").String(); t == "amrap" || t == "timed" {
+			repsExpected = "-" // no rep target
+		}
+		doc.GetElementByID(id + "_reps").SetTextContent(set.Get("reps").String() + " / " + repsExpected)
+		doc.GetElementByID(id + "_weight").SetTextContent(set.Get("weight").String() + " / " + set.Get("weightExpected").String())
+		doc.GetElementByID(id + "_duration").SetTextContent(seconds(set.Get("duration").Int()) + " / " + seconds(set.Get("durationExpected").Int()))
+		doc.GetElementByID(id + "_rest").SetTextContent(seconds(set.Get("rest").Int()) + " / " + seconds(set.Get("restExpected").Int()))
+		records := ""
+		if kinds := set.Get("records"); kinds != js.Undefined && kinds.Length() > 0 {
+			records = "PR: " + kinds.Call("join", ", ").String()
+		}
+		doc.GetElementByID(id + "_records").SetTextContent(records)
 	})
+
+	for _, kind := range []string{"workout-updated", "workout-started", "workout-finished",
+		"exercise-added", "exercise-updated", "exercise-removed",
+		"set-added", "set-removed", "set-started", "set-completed",
+		"rest-started", "rest-extended", "rest-ended"} {
+		source.Call("addEventListener", kind, func(evt *js.Object) {
+			reload()
+		})
+	}
+
+	if liveStatus != nil {
+		source.Set("onerror", func(evt *js.Object) {
+			liveStatus.SetTextContent("(reconnecting...)")
+		})
+		source.Set("onopen", func(evt *js.Object) {
+			liveStatus.SetTextContent("")
+		})
+	}
 }
 
 var doc dom.Document
@@ -235,7 +409,9 @@ var doc dom.Document
 func main() {
 	doc = dom.GetWindow().Document()
 	js.Global.Set("pageAdminUsers", pageAdminUsers)
+	js.Global.Set("pageWorkout", pageWorkout)
 	js.Global.Set("pageAdminExercises", pageAdminExercises)
+	js.Global.Set("pageAdminExerciseEdit", pageAdminExerciseEdit)
 	js.Global.Set("pageAdminWorkouts", pageAdminWorkouts)
 	js.Global.Set("pageAdminWorkoutEdit", pageAdminWorkoutEdit)
 	js.Global.Set("pageAdminSetEdit", pageAdminSetEdit)
@@ -1,5 +1,359 @@
 package main
 
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/BrianWill/WorkoutTracker/store"
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+const usage = `usage: initDB [-dev] [-sqlite path] <command> [args]
+
+Targets the SQLite file in dev mode (-dev or DEV=1), else the Postgres database at $DATABASE_URL.
+
+commands:
+  init                               create the schema (same as migrate)
+  migrate [-to version]              apply migrations up to version (default latest)
+  rollback [-to version | -steps n]  roll back migrations (default one step)
+  seed                               add the canned exercises to the catalog
+  create-admin -name n -password p   create an admin user
+  set-role -name n -role r           make a user an admin (-role admin) or not (-role user)
+  revoke-sessions -name n            log out every device of a user
+  export -name n [-format csv|json]  write every workout of a user to stdout
+  import -name n -file f [-format f] [-source-unit kg|lb] [-tz zone] [-dry-run]
+                                     import a Strong, Hevy or FitNotes CSV export for a user
+  add-alias -alias a -exercise e     make imports match the name a as the catalog exercise e
+  backup -name n                     write a full backup of a user to stdout
+  restore -file f [-name n] [-replace] [-keep-role]
+                                     restore a backup, as user n if given; -replace restores
+                                     over an existing user of another password, replacing
+                                     their workouts and logging them out; -keep-role takes
+                                     the role of the backup
+  version                            print the current and latest schema versions
+`
+
 func main() {
+	log.SetFlags(0)
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	dev := flag.Bool("dev", os.Getenv("DEV") == "1", "use the SQLite database")
+	sqlitePath := flag.String("sqlite", store.SQLiteFilePath, "SQLite database file")
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var db sqlbuilder.Database
+	var err error
+	if *dev {
+		db, err = store.OpenSQLite(*sqlitePath)
+	} else {
+		url := os.Getenv("DATABASE_URL")
+		if url == "" {
+			log.Fatal("$DATABASE_URL must be set (or use -dev)")
+		}
+		db, err = store.OpenPostgres(url)
+	}
+	if err != nil {
+		log.Fatalf("Error opening database: %s", err)
+	}
+	defer db.Close()
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	switch cmd {
+	case "init", "migrate":
+		err = migrate(db, args)
+	case "rollback":
+		err = rollback(db, args)
+	case "seed":
+		err = seed(db)
+	case "create-admin":
+		err = createAdmin(db, args)
+	case "set-role":
+		err = setRole(db, args)
+	case "revoke-sessions":
+		err = revokeSessions(db, args)
+	case "export":
+		err = export(db, args)
+	case "import":
+		err = importExport(db, args)
+	case "add-alias":
+		err = addAlias(db, args)
+	case "backup":
+		err = backup(db, args)
+	case "restore":
+		err = restore(db, args)
+	case "version":
+		err = version(db)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s: %s", cmd, err)
+	}
+}
+
+func migrate(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := fs.Int("to", store.Latest(), "target schema version")
+	fs.Parse(args)
+	if err := store.MigrateTo(db, *to); err != nil {
+		return err
+	}
+	return version(db)
+}
+
+func rollback(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	to := fs.Int("to", -1, "target schema version")
+	steps := fs.Int("steps", 1, "number of migrations to roll back")
+	fs.Parse(args)
+	current, err := store.Version(db)
+	if err != nil {
+		return err
+	}
+	target := *to
+	if target < 0 {
+		target = current - *steps
+	}
+	if target > current {
+		return fmt.Errorf("schema is at version %d, cannot roll back to %d", current, target)
+	}
+	if err := store.MigrateTo(db, target); err != nil {
+		return err
+	}
+	return version(db)
+}
+
+func seed(db sqlbuilder.Database) error {
+	added, err := store.SeedExercises(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added %d exercises to the catalog\n", added)
+	return nil
+}
+
+func createAdmin(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	name := fs.String("name", "", "user name")
+	password := fs.String("password", "", "password")
+	fs.Parse(args)
+	if *name == "" || *password == "" {
+		return fmt.Errorf("-name and -password are required")
+	}
+	user, err := store.CreateUser(db, *name, *password)
+	if err != nil {
+		return err
+	}
+	if err := store.SetRole(db, user.ID, store.RoleAdmin); err != nil {
+		return err
+	}
+	fmt.Printf("created admin %q with id %d\n", user.Name, user.ID)
+	return nil
+}
+
+func setRole(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("set-role", flag.ExitOnError)
+	name := fs.String("name", "", "user name")
+	role := fs.String("role", store.RoleAdmin, "user or admin")
+	fs.Parse(args)
+	var user store.UserDB
+	err := db.Collection("users").Find(up.Cond{"name": *name}).One(&user)
+	if err == up.ErrNoMoreRows {
+		return fmt.Errorf("no user %q", *name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.SetRole(db, user.ID, *role); err != nil {
+		return err
+	}
+	fmt.Printf("user %q is now %s\n", user.Name, *role)
+	return nil
+}
+
+func revokeSessions(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("revoke-sessions", flag.ExitOnError)
+	name := fs.String("name", "", "user name")
+	fs.Parse(args)
+	var user store.UserDB
+	err := db.Collection("users").Find(up.Cond{"name": *name}).One(&user)
+	if err == up.ErrNoMoreRows {
+		return fmt.Errorf("no user %q", *name)
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.RevokeUserSessions(db, user.ID); err != nil {
+		return err
+	}
+	fmt.Printf("logged out all sessions of user %q\n", user.Name)
+	return nil
+}
+
+func export(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	name := fs.String("name", "", "user name")
+	format := fs.String("format", "json", "csv or json")
+	fs.Parse(args)
+	var write func(store.Conn, uint64, io.Writer) error
+	switch *format {
+	case "csv":
+		write = store.ExportCSV
+	case "json":
+		write = store.ExportJSON
+	default:
+		return fmt.Errorf("-format must be csv or json")
+	}
+	var user store.UserDB
+	err := db.Collection("users").Find(up.Cond{"name": *name}).One(&user)
+	if err == up.ErrNoMoreRows {
+		return fmt.Errorf("no user %q", *name)
+	}
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	if err := write(db, user.ID, out); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func importExport(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	name := fs.String("name", "", "user name")
+	path := fs.String("file", "", "CSV export")
+	format := fs.String("format", "", "strong, hevy or fitnotes (default: tell from the header)")
+	sourceUnit := fs.String("source-unit", "", "kg or lb, the unit of the export if it doesn't say (default the user's)")
+	tz := fs.String("tz", "UTC", "time zone of the export's times")
+	dryRun := fs.Bool("dry-run", false, "print what would be imported, but save nothing")
+	fs.Parse(args)
+	loc, err := time.LoadLocation(*tz)
+	if err != nil {
+		return err
+	}
+	var user store.UserDB
+	err = db.Collection("users").Find(up.Cond{"name": *name}).One(&user)
+	if err == up.ErrNoMoreRows {
+		return fmt.Errorf("no user %q", *name)
+	}
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	result, err := store.Import(db, user.ID, file, store.ImportOptions{
+		Format:     *format,
+		SourceUnit: *sourceUnit,
+		Location:   loc,
+		DryRun:     *dryRun,
+	})
+	if err == store.ErrUnmatchedExercises {
+		for _, name := range result.Unmatched {
+			fmt.Printf("no catalog exercise for %q\n", name)
+		}
+		return fmt.Errorf("%s; add aliases for them with add-alias", err)
+	}
+	if err != nil {
+		return err
+	}
+	for _, d := range result.Duplicates {
+		fmt.Printf("already logged: %s\n", d)
+	}
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d sessions with %d sets from %s for user %q\n", verb, len(result.Workouts), result.Sets, result.Format, user.Name)
+	return nil
+}
+
+func addAlias(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("add-alias", flag.ExitOnError)
+	alias := fs.String("alias", "", "other name of the exercise")
+	exercise := fs.String("exercise", "", "name of the catalog exercise")
+	fs.Parse(args)
+	var def store.ExerciseDefinitionDB
+	err := db.Collection("exercise_definitions").Find(up.Cond{"name": *exercise}).One(&def)
+	if err == up.ErrNoMoreRows {
+		return fmt.Errorf("no catalog exercise %q", *exercise)
+	}
+	if err != nil {
+		return err
+	}
+	a, err := store.AddAlias(db, *alias, def.ID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%q now matches %q\n", a.Alias, def.Name)
+	return nil
+}
+
+func backup(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	name := fs.String("name", "", "user name")
+	fs.Parse(args)
+	var user store.UserDB
+	err := db.Collection("users").Find(up.Cond{"name": *name}).One(&user)
+	if err == up.ErrNoMoreRows {
+		return fmt.Errorf("no user %q", *name)
+	}
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(os.Stdout)
+	if err := store.WriteBackup(db, user.ID, out); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func restore(db sqlbuilder.Database, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	path := fs.String("file", "", "backup file")
+	name := fs.String("name", "", "user to restore as (default the user of the backup)")
+	replace := fs.Bool("replace", false, "replace the workouts of an existing user")
+	keepRole := fs.Bool("keep-role", false, "take the role of the backup, which may be admin")
+	fs.Parse(args)
+	file, err := os.Open(*path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var b store.Backup
+	if err := json.NewDecoder(bufio.NewReader(file)).Decode(&b); err != nil {
+		return fmt.Errorf("reading %s: %s", *path, err)
+	}
+	user, err := store.Restore(db, b, store.RestoreOptions{Name: *name, Replace: *replace, KeepRole: *keepRole})
+	if err == store.ErrUserExists {
+		return fmt.Errorf("%s; use -replace to restore over them, or -name", err)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %d workouts as user %q with id %d\n", len(b.Workouts), user.Name, user.ID)
+	return nil
+}
 
+func version(db sqlbuilder.Database) error {
+	current, err := store.Version(db)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("schema version %d (latest %d)\n", current, store.Latest())
+	return nil
 }
@@ -2,133 +2,184 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
-	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
 	_ "github.com/heroku/x/hmetrics/onload"
 
-	uuid "github.com/satori/go.uuid"
-	up "upper.io/db.v3"
+	"github.com/BrianWill/WorkoutTracker/store"
 	"upper.io/db.v3/lib/sqlbuilder"
-	"upper.io/db.v3/postgresql"
-	"upper.io/db.v3/sqlite"
 )
 
-const sqliteFilePath = "userData.dat"
 const timeFormat = "15:04 Mon _2 Jan 2006"
+const sessionCookie = "session"
 
-func initPostgres(db sqlbuilder.Database) error {
+// secureCookies restricts cookies to HTTPS outside of dev mode.
+var secureCookies bool
 
-	return nil
+func setSessionCookie(c *gin.Context, token string) {
+	writeSessionCookie(c, token, int(store.SessionLifetime/time.Second))
 }
 
-type UserDB struct {
-	ID       uint64 `db:"id,omitempty"`
-	Name     string `db:"name"`
-	Cookie   string `db:"cookie"`
-	Password string `db:"password"`
+func clearSessionCookie(c *gin.Context) {
+	writeSessionCookie(c, "", -1)
 }
 
-type ExerciseDB struct {
-	ID    uint64 `db:"id,omitempty"`
-	Name  string `db:"name" json:"name"`
-	Notes string `db:"notes" json:"notes"`
+// writeSessionCookie sets the session cookie SameSite=Lax, which gin's
+// SetCookie can't, so other sites can't post forms with it.
+func writeSessionCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   secureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
 }
 
-type Exercise struct {
-	ExerciseDB
-	Sets []SetDB
+// sessionUser returns the user logged in with the request's session cookie.
+// If the session token was rotated, the replacement is sent back in the response.
+func sessionUser(c *gin.Context, db sqlbuilder.Database) (store.UserDB, error) {
+	token, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return store.UserDB{}, store.ErrNoSession
+	}
+	user, rotated, err := store.SessionUser(db, token)
+	if err != nil {
+		return store.UserDB{}, err
+	}
+	if rotated != "" {
+		setSessionCookie(c, rotated)
+	}
+	return user, nil
 }
 
-type WorkoutDB struct {
-	ID           uint64 `db:"id,omitempty"`
-	Name         string `db:"name" json:"name"`
-	StartTime    uint64 `db:"startTime" json:"startTime"`
-	StartTimeStr string
-	EndTime      uint64 `db:"endTime" json:"endTime"`
-	User         uint64 `db:"user" json:"user"`
-}
+const userKey = "user"
 
-type Workout struct {
-	WorkoutDB
-	Exercises []Exercise
+// requireUser is middleware that stores the logged-in user in the context
+// under userKey. Requests without a valid session are sent to the login page.
+func requireUser(db sqlbuilder.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, err := sessionUser(c, db)
+		if err == store.ErrNoSession {
+			c.Redirect(http.StatusSeeOther, "/login")
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error reading user info. "+err.Error())
+			c.Abort()
+			return
+		}
+		c.Set(userKey, user)
+		c.Next()
+	}
 }
 
-type SetDB struct {
-	ID               uint64 `db:"id,omitempty"`
-	Order            int    `db:"order"` // sets of an exercise have a relative order
-	Reps             int    `db:"reps"`
-	Weight           int    `db:"weight"`
-	Duration         int    `db:"duration"` // time in milliseconds of time to perform set
-	Rest             int    `db:"rest"`     // time in milliseconds of rest before next exercise
-	RepsExpected     int    `db:"repsExpected"`
-	WeightExpected   int    `db:"weightExpected"`
-	DurationExpected int    `db:"durationExpected"` // time in milliseconds of time to perform set
-	RestExpected     int    `db:"restExpected"`     // time in milliseconds of rest before next exercise
+// currentUser returns the user stored by requireUser.
+func currentUser(c *gin.Context) store.UserDB {
+	return c.MustGet(userKey).(store.UserDB)
 }
 
-func initSqlite(db sqlbuilder.Database) error {
-	if _, err := db.Exec(
-		`CREATE TABLE IF NOT EXISTS users(
-			id INTEGER PRIMARY KEY,
-			name TEXT NOT NULL,
-			cookie TEXT NOT NULL,
-			password TEXT NOT NULL
-		)`); err != nil {
-		return err
+// requireAdmin is middleware that rejects users without the admin role.
+// It must come after requireUser.
+func requireAdmin(c *gin.Context) {
+	if currentUser(c).Role != store.RoleAdmin {
+		c.String(http.StatusForbidden, "Admins only.")
+		c.Abort()
+		return
 	}
+	c.Next()
+}
 
-	if _, err := db.Exec(
-		`CREATE TABLE IF NOT EXISTS exercises(
-			id INTEGER PRIMARY KEY,
-			name TEXT NOT NULL,
-			notes TEXT NOT NULL,
-			workout INTEGER NOT NULL,
-			FOREIGN KEY (workout) REFERENCES workouts(id)
-		)`); err != nil {
-		return err
+// liveSessionError answers with the status matching an error of the live
+// session flow and reports whether the request can go on, i.e. err is nil.
+func liveSessionError(c *gin.Context, prefix string, err error) bool {
+	switch err {
+	case nil:
+		return true
+	case store.ErrNotFound:
+		c.String(http.StatusNotFound, prefix+"No record matching that ID.")
+	case store.ErrTemplateLive, store.ErrFinishTemplate:
+		c.String(http.StatusBadRequest, prefix+err.Error())
+	case store.ErrWorkoutFinished, store.ErrWorkoutNotEmpty, store.ErrSetStarted, store.ErrSetNotStarted,
+		store.ErrSetCompleted, store.ErrSetInProgress, store.ErrNoRestTimer:
+		c.String(http.StatusConflict, prefix+err.Error())
+	default:
+		c.String(http.StatusInternalServerError, prefix+err.Error())
 	}
+	return false
+}
+
+// exports are the formats of a data export, with how each is written and served.
+var exports = map[string]struct {
+	contentType string
+	write       func(store.Conn, uint64, io.Writer) error
+}{
+	"csv":  {"text/csv; charset=utf-8", store.ExportCSV},
+	"json": {"application/json; charset=utf-8", store.ExportJSON},
+}
 
-	if _, err := db.Exec(
-		`CREATE TABLE IF NOT EXISTS workouts(
-			id INTEGER PRIMARY KEY,
-			name TEXT NOT NULL,
-			startTime INTEGER NOT NULL,
-			endTime INTEGER NOT NULL,
-			user INTEGER NOT NULL,
-			FOREIGN KEY (user) REFERENCES users(id)
-		)`); err != nil {
-		return err
+// streamExport serves every workout of the current user as a download in a
+// format of exports. The export is written as it is read, so once it has
+// begun an error can only cut the download short.
+func streamExport(c *gin.Context, db sqlbuilder.Database, format string) {
+	user := currentUser(c)
+	c.Header("Content-Type", exports[format].contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="workouts-%s.%s"`, time.Now().UTC().Format("2006-01-02"), format))
+	c.Status(http.StatusOK)
+	if err := exports[format].write(db, user.ID, c.Writer); err != nil {
+		log.Printf("Error exporting the workouts of user %d: %s", user.ID, err)
 	}
+}
 
-	//
-	if _, err := db.Exec(
-		`CREATE TABLE IF NOT EXISTS sets(
-			id INTEGER PRIMARY KEY,
-			order            INTEGER NOT NULL,    /* first is 0, second is 1, etc. */
-			reps     		 INTEGER NOT NULL,
-			weight   		 INTEGER NOT NULL,
-			duration 		 INTEGER NOT NULL,
-			rest     		 INTEGER NOT NULL,
-			repsExpected     INTEGER NOT NULL,
-			weightExpected   INTEGER NOT NULL,
-			durationExpected INTEGER NOT NULL,
-			restExpected     INTEGER NOT NULL,
-			exercise INTEGER NOT NULL,
-			FOREIGN KEY (exercise) REFERENCES exercises(id),
-		)`); err != nil {
-		return err
+// streamBackup serves a full backup of the current user as a download,
+// written as it is read like an export.
+func streamBackup(c *gin.Context, db sqlbuilder.Database) {
+	user := currentUser(c)
+	c.Header("Content-Type", "application/json; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="backup-%s-%s.json"`, user.Name, time.Now().UTC().Format("2006-01-02")))
+	c.Status(http.StatusOK)
+	if err := store.WriteBackup(db, user.ID, c.Writer); err != nil {
+		log.Printf("Error backing up user %d: %s", user.ID, err)
 	}
+}
 
-	return nil
+// maxImportSize is the largest request the import page and API take, in
+// bytes, the export included.
+const maxImportSize = 10 << 20
+
+// importPage is the data of the import page, carried from the upload
+// through previews to the import.
+type importPage struct {
+	Data       string // the CSV export, once uploaded
+	Format     string
+	SourceUnit string
+	TimeZone   string
+	Exercises  map[string]uint64 // catalog exercises picked for names matching none
+	Catalog    []store.ExerciseDefinitionDB
+	Result     *store.ImportResult
+	Done       bool // imported, not previewed
+	Error      string
+}
+
+// idParam parses a record ID from the named URL parameter.
+func idParam(c *gin.Context, name string) (uint64, error) {
+	return strconv.ParseUint(c.Param(name), 10, 64)
 }
 
 func main() {
@@ -138,174 +189,539 @@ func main() {
 		log.Fatal("$PORT must be set")
 	}
 	dev := os.Getenv("DEV") == "1"
+	secureCookies = !dev
 
 	var err error
 	var db sqlbuilder.Database
 	if dev {
 		fmt.Println("DEV MODE")
-		db, err = sqlite.Open(sqlite.ConnectionURL{sqliteFilePath, nil})
+		db, err = store.OpenSQLite(store.SQLiteFilePath)
 	} else {
 		fmt.Println("PRODUCTION MODE")
-		connURL, connErr := postgresql.ParseURL(os.Getenv("DATABASE_URL"))
-		if connErr != nil {
-			log.Fatalf("Error with Postgres connection string: %q", err)
-		}
-		db, err = postgresql.Open(connURL)
+		db, err = store.OpenPostgres(os.Getenv("DATABASE_URL"))
 	}
 	if err != nil {
 		log.Fatalf("Error opening database: %q", err)
 	}
 	defer db.Close()
-	if dev {
-		err = initSqlite(db)
-	} else {
-		err = initPostgres(db)
-	}
-	if err != nil {
+	if err = store.Migrate(db); err != nil {
 		log.Fatalf("Error initializing database: %s", err)
 	}
+	newRouter(db).Run(":" + port)
+}
 
+// newRouter returns the router of every page and endpoint, served from db.
+// Templates and static files are read from the working directory.
+func newRouter(db sqlbuilder.Database) *gin.Engine {
 	router := gin.New()
 	router.Use(gin.Logger())
+	router.SetFuncMap(template.FuncMap{
+		"inc": func(i int) int {
+			return i + 1
+		},
+		"seconds": func(ms int) string {
+			return strconv.FormatFloat(float64(ms)/1000, 'f', -1, 64) + "s"
+		},
+		"join": strings.Join,
+		// the value of a personal record in its unit
+		"recordValue": func(r store.Record) string {
+			if r.Kind == store.RecordDuration {
+				return strconv.FormatFloat(r.Value/1000, 'f', -1, 64) + "s"
+			}
+			return strconv.FormatFloat(r.Value, 'f', -1, 64)
+		},
+		// the time of a unix time in milliseconds
+		"millis": func(ms int64) string {
+			return time.Unix(0, ms*int64(time.Millisecond)).Format(timeFormat)
+		},
+		// the time of a unix time in seconds
+		"unix": func(sec uint64) string {
+			return time.Unix(int64(sec), 0).Format(timeFormat)
+		},
+		// m:ss of a countdown, rounded up; negative once overrun
+		"countdown": func(ms int64) string {
+			sign := ""
+			if ms < 0 {
+				sign, ms = "-", -ms
+			}
+			s := (ms + 999) / 1000
+			return fmt.Sprintf("%s%d:%02d", sign, s/60, s%60)
+		},
+	})
 	router.LoadHTMLGlob("templates/*.tmpl")
 	router.Static("/static", "static")
 
 	router.Static("/gojs", "gojs")
 
-	router.GET("/", func(c *gin.Context) {
-		userCookie, err := c.Cookie("user_id")
+	authed := router.Group("/", requireUser(db))
+	admin := authed.Group("/", requireAdmin)
+	live := newHub()
+	registerAPI(router, db, live)
+	registerCharts(authed, db)
+
+	authed.GET("/", func(c *gin.Context) {
+		workouts, err := store.UserSessions(db, currentUser(c).ID)
 		if err != nil {
-			c.Redirect(http.StatusSeeOther, "/login")
+			c.String(http.StatusInternalServerError, "Error reading user workouts. "+err.Error())
+			return
+		}
+		for i, v := range workouts {
+			workouts[i].StartTimeStr = time.Unix(int64(v.StartTime), 0).Format(timeFormat)
+		}
+		c.HTML(http.StatusOK, "home.tmpl", struct {
+			Sessions []store.WorkoutDB
+			User     store.UserDB
+		}{workouts, currentUser(c)})
+	})
+
+	// the unit weights are shown in and the plate increment; a changed unit
+	// resets the increment, as it was given in the old one
+	authed.POST("/preferences", func(c *gin.Context) {
+		user := currentUser(c)
+		unit := c.PostForm("unit")
+		patch := store.PreferencesPatch{Unit: &unit}
+		if unit == user.Unit {
+			increment, err := store.ParseWeight(c.PostForm("plateIncrement"))
+			if err != nil {
+				c.String(http.StatusBadRequest, "Couldn't save preferences. "+err.Error())
+				return
+			}
+			patch.PlateIncrement = &increment
+		}
+		_, err := store.UpdatePreferences(db, user.ID, patch)
+		switch err {
+		case nil:
+		case store.ErrBadUnit, store.ErrBadPlateIncrement:
+			c.String(http.StatusBadRequest, "Couldn't save preferences. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Couldn't save preferences. "+err.Error())
 			return
 		}
-		user := UserDB{}
-		err = db.Collection("users").Find(up.Cond{"cookie": userCookie}).One(&user)
+		c.Redirect(http.StatusSeeOther, "/")
+	})
+
+	// a catalog exercise with the user's current records, progress and every session of it
+	authed.GET("/exercise/:id", func(c *gin.Context) {
+		definitionID, err := idParam(c, "id")
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error reading user info. "+err.Error())
+			c.String(http.StatusBadRequest, "Invalid exercise ID.")
+			return
+		}
+		unit := currentUser(c).Unit
+		history, err := store.History(db, currentUser(c).ID, definitionID)
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "No exercise matching that ID.")
 			return
 		}
-		workouts := []WorkoutDB{}
-		err = db.Collection("workouts").Find(up.Cond{"user": user.ID}).All(&workouts)
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error reading user workouts. "+err.Error())
+			c.String(http.StatusInternalServerError, "Error reading exercise history. "+err.Error())
+			return
+		}
+		records, err := store.Records(db, currentUser(c).ID, definitionID)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error reading records. "+err.Error())
+			return
+		}
+		// the latest record of each kind is the current one; reps records are per weight
+		type key struct {
+			kind   string
+			weight store.Weight
+		}
+		var current []store.Record
+		index := map[key]int{}
+		for _, r := range records {
+			r = r.ToUnit(unit)
+			k := key{r.Kind, r.Weight}
+			if i, ok := index[k]; ok {
+				current[i] = r
+				continue
+			}
+			index[k] = len(current)
+			current = append(current, r)
+		}
+		c.HTML(http.StatusOK, "exercise.tmpl", struct {
+			store.ExerciseHistory
+			Records []store.Record
+			Unit    string
+		}{history.ToUnit(unit), current, unit})
+	})
+
+	// a full backup of the current user, to restore with initDB restore
+	authed.GET("/backup", func(c *gin.Context) {
+		streamBackup(c, db)
+	})
+
+	authed.GET("/import", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "import.tmpl", importPage{})
+	})
+
+	// previews the import unless the Import button was pressed; see store.Import
+	authed.POST("/import", func(c *gin.Context) {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+		if err := c.Request.ParseMultipartForm(maxImportSize); err != nil && err != http.ErrNotMultipart {
+			c.String(http.StatusBadRequest, "Error reading upload. "+err.Error())
 			return
 		}
-		sort.Slice(workouts, func(i, j int) bool {
-			return workouts[i].StartTime < workouts[j].StartTime
+		page := importPage{
+			Data:       c.PostForm("data"),
+			Format:     c.PostForm("format"),
+			SourceUnit: c.PostForm("sourceUnit"),
+			TimeZone:   c.PostForm("timeZone"),
+			Exercises:  map[string]uint64{},
+		}
+		if page.Data == "" {
+			header, err := c.FormFile("file")
+			if err != nil {
+				c.String(http.StatusBadRequest, "No CSV file uploaded.")
+				return
+			}
+			file, err := header.Open()
+			if err != nil {
+				c.String(http.StatusInternalServerError, "Error reading upload. "+err.Error())
+				return
+			}
+			data, err := ioutil.ReadAll(file)
+			file.Close()
+			if err != nil {
+				c.String(http.StatusInternalServerError, "Error reading upload. "+err.Error())
+				return
+			}
+			page.Data = string(data)
+		}
+		names, ids := c.PostFormArray("unmatched"), c.PostFormArray("exercise")
+		for i := 0; i < len(names) && i < len(ids); i++ {
+			if id, err := strconv.ParseUint(ids[i], 10, 64); err == nil {
+				page.Exercises[names[i]] = id
+			}
+		}
+		var err error
+		if page.Catalog, err = store.Catalog(db); err != nil {
+			c.String(http.StatusInternalServerError, "Error reading exercises. "+err.Error())
+			return
+		}
+		loc, err := time.LoadLocation(page.TimeZone)
+		if err != nil {
+			page.Error = "Unknown time zone " + page.TimeZone + "."
+			c.HTML(http.StatusBadRequest, "import.tmpl", page)
+			return
+		}
+		result, err := store.Import(db, currentUser(c).ID, strings.NewReader(page.Data), store.ImportOptions{
+			Format:     page.Format,
+			SourceUnit: page.SourceUnit,
+			Location:   loc,
+			Exercises:  page.Exercises,
+			DryRun:     c.PostForm("preview") != "",
 		})
-		for i, v := range workouts {
-			workouts[i].StartTimeStr = time.Unix(int64(v.StartTime), 0).Format(timeFormat)
+		if _, ok := err.(*store.ImportError); ok || err == store.ErrBadUnit {
+			page.Data, page.Error = "", err.Error() // to upload another file
+			c.HTML(http.StatusUnprocessableEntity, "import.tmpl", page)
+			return
+		}
+		if err != nil && err != store.ErrUnmatchedExercises {
+			c.String(http.StatusInternalServerError, "Error importing workouts. "+err.Error())
+			return
 		}
-		c.HTML(http.StatusOK, "home.tmpl", workouts)
+		for i, w := range result.Workouts {
+			result.Workouts[i] = w.ToUnit(currentUser(c).Unit)
+		}
+		page.Result, page.Done = &result, err == nil && !result.DryRun
+		c.HTML(http.StatusOK, "import.tmpl", page)
 	})
 
-	router.GET("/login", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "login.tmpl", nil)
+	// format is csv or json
+	authed.GET("/export/:format", func(c *gin.Context) {
+		if _, ok := exports[c.Param("format")]; !ok {
+			c.String(http.StatusNotFound, "Export formats are csv and json.")
+			return
+		}
+		streamExport(c, db, c.Param("format"))
 	})
 
-	router.POST("/login", func(c *gin.Context) {
-		// todo: put in transaction
+	authed.GET("/newWorkout", func(c *gin.Context) {
+		templates, err := store.UserTemplates(db, currentUser(c).ID)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error reading templates. "+err.Error())
+			return
+		}
+		c.HTML(http.StatusOK, "new_workout.tmpl", templates)
+	})
 
-		name := c.PostForm("username")
-		password := c.PostForm("password")
-		var user UserDB
-		err := db.Collection("users").Find(up.Cond{"name": name, "password": password}).One(&user)
+	authed.GET("/templates", func(c *gin.Context) {
+		templates, err := store.UserTemplates(db, currentUser(c).ID)
 		if err != nil {
-			c.String(http.StatusUnauthorized, "Bad user name and/or password.")
+			c.String(http.StatusInternalServerError, "Error reading templates. "+err.Error())
 			return
 		}
+		c.HTML(http.StatusOK, "templates.tmpl", templates)
+	})
 
-		u2 := uuid.NewV4()
-		userID := u2.String()
-		const tenYears = 10 * 365 * 24 * 60 * 60
-		c.SetCookie("user_id", userID, tenYears, "/", "", false, false)
+	authed.POST("/createTemplate", func(c *gin.Context) {
+		_, err := store.CreateTemplate(db, currentUser(c).ID, c.PostForm("name"))
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error creating template. "+err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/templates")
+	})
 
-		user.Cookie = userID
-		err = db.Collection("users").Find(user.ID).Update(user)
+	authed.GET("/createTemplate/:id", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Bad user name and/or password.")
+			c.String(http.StatusBadRequest, "Invalid workout ID.")
 			return
 		}
-		c.Redirect(http.StatusSeeOther, "/")
+		_, err = store.SaveAsTemplate(db, currentUser(c).ID, workoutID)
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "No workout matching that ID.")
+			return
+		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error creating template. "+err.Error())
+			return
+		}
+		c.Redirect(http.StatusSeeOther, "/templates")
 	})
 
-	router.POST("/createAccount", func(c *gin.Context) {
-		name := c.PostForm("username")
-		password := c.PostForm("password")
+	authed.POST("/renameWorkout/:id", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid workout ID.")
+			return
+		}
+		workout, err := store.RenameWorkout(db, currentUser(c).ID, workoutID, c.PostForm("name"))
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			c.String(http.StatusNotFound, "No workout matching that ID.")
+			return
+		case store.ErrBadWorkoutName:
+			c.String(http.StatusBadRequest, "Error renaming workout. "+err.Error())
+			return
+		case store.ErrWorkoutFinished:
+			c.String(http.StatusConflict, "Error renaming workout. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Error renaming workout. "+err.Error())
+			return
+		}
+		live.publish(workout.ID, eventWorkoutUpdated, workout)
+		c.Redirect(http.StatusSeeOther, "/workout/"+c.Param("id"))
+	})
 
-		// todo: use transaction; verify that name and password are valid
-		fmt.Println("create account with name & password: ", name, password)
+	authed.POST("/addExercise/:id", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid workout ID.")
+			return
+		}
+		definitionID, err := strconv.ParseUint(c.PostForm("definition"), 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid exercise ID.")
+			return
+		}
+		exercise, err := store.AddExercise(db, currentUser(c).ID, workoutID, definitionID, c.PostForm("notes"))
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			c.String(http.StatusNotFound, "No workout or catalog exercise matching that ID.")
+			return
+		case store.ErrWorkoutFinished:
+			c.String(http.StatusConflict, "Error adding exercise. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Error adding exercise. "+err.Error())
+			return
+		}
+		live.publish(workoutID, eventExerciseAdded, exercise)
+		c.Redirect(http.StatusSeeOther, "/workout/"+c.Param("id"))
+	})
 
-		u2 := uuid.NewV4()
-		userID := u2.String()
-		const tenYears = 10 * 365 * 24 * 60 * 60
-		c.SetCookie("user_id", userID, tenYears, "/", "", false, false)
+	// a live session: start the workout, start and complete each set, finish
 
-		_, err := db.Collection("users").Insert(UserDB{
-			Name:     name,
-			Password: password,
-			Cookie:   userID,
-		})
+	// streams the changes to the workout as Server-Sent Events, see events.go
+	authed.GET("/workout/:id/events", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error creating new user. "+err.Error())
+			c.String(http.StatusBadRequest, "Invalid workout ID.")
+			return
+		}
+		_, err = store.UserWorkout(db, currentUser(c).ID, workoutID)
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "No workout matching that ID.")
 			return
 		}
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error reading workout. "+err.Error())
+			return
+		}
+		live.stream(c, workoutID)
+	})
 
-		c.Redirect(http.StatusSeeOther, "/")
+	authed.POST("/workout/:id/start", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid workout ID.")
+			return
+		}
+		workout, err := store.StartWorkout(db, currentUser(c).ID, workoutID)
+		if !liveSessionError(c, "Error starting workout. ", err) {
+			return
+		}
+		live.publish(workoutID, eventWorkoutStarted, workout)
+		c.Redirect(http.StatusSeeOther, "/workout/"+c.Param("id"))
 	})
 
-	router.GET("/createWorkout", func(c *gin.Context) {
-		userCookie, err := c.Cookie("user_id")
+	authed.POST("/workout/:id/startSet/:setID", func(c *gin.Context) {
+		setID, err := idParam(c, "setID")
 		if err != nil {
-			c.Redirect(http.StatusSeeOther, "/login")
+			c.String(http.StatusBadRequest, "Invalid set ID.")
+			return
+		}
+		set, err := store.StartSet(db, currentUser(c).ID, setID)
+		if !liveSessionError(c, "Error starting set. ", err) {
 			return
 		}
-		user := UserDB{}
-		err = db.Collection("users").Find(up.Cond{"cookie": userCookie}).One(&user)
+		live.publishStarted(db, currentUser(c).ID, set)
+		c.Redirect(http.StatusSeeOther, "/workout/"+c.Param("id"))
+	})
+
+	authed.POST("/workout/:id/completeSet/:setID", func(c *gin.Context) {
+		setID, err := idParam(c, "setID")
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error reading user info. "+err.Error())
+			c.String(http.StatusBadRequest, "Invalid set ID.")
 			return
 		}
-		workout := WorkoutDB{
-			Name:      "new session",
-			User:      user.ID,
-			StartTime: uint64(time.Now().Unix()),
+		set, err := store.CompleteSet(db, currentUser(c).ID, setID)
+		if !liveSessionError(c, "Error completing set. ", err) {
+			return
 		}
-		_, err = db.Collection("workouts").Insert(workout)
+		live.publishCompleted(db, currentUser(c).ID, set)
+		c.Redirect(http.StatusSeeOther, "/workout/"+c.Param("id"))
+	})
+
+	// form: seconds, negative to shorten the rest
+	authed.POST("/workout/:id/rest/extend", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error creating new workout session. "+err.Error())
+			c.String(http.StatusBadRequest, "Invalid workout ID.")
 			return
 		}
-		c.Redirect(http.StatusSeeOther, "/")
+		seconds, err := strconv.ParseInt(c.PostForm("seconds"), 10, 64)
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid number of seconds.")
+			return
+		}
+		timer, err := store.ExtendRest(db, currentUser(c).ID, workoutID, seconds*1000)
+		if !liveSessionError(c, "Error extending rest. ", err) {
+			return
+		}
+		live.publish(workoutID, eventRestExtended, timer)
+		c.Redirect(http.StatusSeeOther, "/workout/"+c.Param("id"))
+	})
+
+	authed.POST("/workout/:id/rest/skip", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid workout ID.")
+			return
+		}
+		timer, err := store.SkipRest(db, currentUser(c).ID, workoutID)
+		if !liveSessionError(c, "Error skipping rest. ", err) {
+			return
+		}
+		live.publish(workoutID, eventRestEnded, timer)
+		c.Redirect(http.StatusSeeOther, "/workout/"+c.Param("id"))
 	})
 
-	router.GET("/createWorkout/:id", func(c *gin.Context) {
-		workoutID, err := strconv.Atoi(c.Param("id"))
+	authed.POST("/workout/:id/finish", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
 		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid workout ID.")
 			return
 		}
-		userCookie, err := c.Cookie("user_id")
+		workout, err := store.FinishWorkout(db, currentUser(c).ID, workoutID)
+		if !liveSessionError(c, "Error finishing workout. ", err) {
+			return
+		}
+		live.publish(workoutID, eventWorkoutFinished, workout)
+		c.Redirect(http.StatusSeeOther, "/workout/"+c.Param("id"))
+	})
+
+	router.GET("/login", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "login.tmpl", nil)
+	})
+
+	router.POST("/login", func(c *gin.Context) {
+		name := c.PostForm("username")
+		password := c.PostForm("password")
+		user, err := store.Authenticate(db, name, password)
+		if err == store.ErrBadLogin {
+			c.String(http.StatusUnauthorized, "Bad user name and/or password.")
+			return
+		}
 		if err != nil {
-			c.Redirect(http.StatusSeeOther, "/login")
+			c.String(http.StatusInternalServerError, "Error logging in. "+err.Error())
 			return
 		}
-		user := UserDB{}
-		err = db.Collection("users").Find(up.Cond{"cookie": userCookie}).One(&user)
+		token, err := store.NewSession(db, user.ID, c.Request.UserAgent())
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error reading user info. "+err.Error())
+			c.String(http.StatusInternalServerError, "Error starting session. "+err.Error())
+			return
+		}
+		setSessionCookie(c, token)
+		c.Redirect(http.StatusSeeOther, "/")
+	})
+
+	router.POST("/createAccount", func(c *gin.Context) {
+		name := c.PostForm("username")
+		password := c.PostForm("password")
+		user, err := store.CreateUser(db, name, password)
+		switch err {
+		case nil:
+		case store.ErrBadUserName, store.ErrWeakPassword, store.ErrUserExists:
+			c.String(http.StatusBadRequest, "Error creating new user. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Error creating new user. "+err.Error())
 			return
 		}
-		var workout WorkoutDB
-		err = db.Collection("workouts").Find(workoutID).One(&workout)
+		token, err := store.NewSession(db, user.ID, c.Request.UserAgent())
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error deleting workout session. "+err.Error())
+			c.String(http.StatusInternalServerError, "Error starting session. "+err.Error())
 			return
 		}
-		workout.ID = 0 // must be zero for auto-increment ID
-		workout.StartTime = uint64(time.Now().Unix())
-		// todo: copy all exercises associated with the workout
-		_, err = db.Collection("workouts").Insert(workout)
+		setSessionCookie(c, token)
+		c.Redirect(http.StatusSeeOther, "/")
+	})
+
+	router.GET("/logout", func(c *gin.Context) {
+		if token, err := c.Cookie(sessionCookie); err == nil {
+			if err := store.RevokeSession(db, token); err != nil {
+				c.String(http.StatusInternalServerError, "Error ending session. "+err.Error())
+				return
+			}
+		}
+		clearSessionCookie(c)
+		c.Redirect(http.StatusSeeOther, "/login")
+	})
+
+	authed.POST("/logoutEverywhere", func(c *gin.Context) {
+		if err := store.RevokeUserSessions(db, currentUser(c).ID); err != nil {
+			c.String(http.StatusInternalServerError, "Error ending sessions. "+err.Error())
+			return
+		}
+		clearSessionCookie(c)
+		c.Redirect(http.StatusSeeOther, "/login")
+	})
+
+	authed.GET("/createWorkout", func(c *gin.Context) {
+		workout := store.WorkoutDB{
+			Name:      "new session",
+			User:      currentUser(c).ID,
+			StartTime: uint64(time.Now().Unix()),
+		}
+		_, err := db.Collection("workouts").Insert(workout)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error creating new workout session. "+err.Error())
 			return
@@ -313,73 +729,78 @@ func main() {
 		c.Redirect(http.StatusSeeOther, "/")
 	})
 
-	router.GET("/workout/:id", func(c *gin.Context) {
-		workoutID, err := strconv.Atoi(c.Param("id"))
+	authed.GET("/createWorkout/:id", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
 		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid workout ID.")
 			return
 		}
-		userCookie, err := c.Cookie("user_id")
-		if err != nil {
-			c.Redirect(http.StatusSeeOther, "/login")
+		_, err = store.CopyWorkout(db, currentUser(c).ID, workoutID)
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "No workout matching that ID.")
 			return
 		}
-		user := UserDB{}
-		err = db.Collection("users").Find(up.Cond{"cookie": userCookie}).One(&user)
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error reading user info. "+err.Error())
+			c.String(http.StatusInternalServerError, "Error creating new workout session. "+err.Error())
 			return
 		}
+		c.Redirect(http.StatusSeeOther, "/")
+	})
 
-		q := db.Select("w.id AS workout_id", "e.id AS exercise_id", "s.id AS set_id", "*").
-			From("workouts AS w").Where("w.user = ?", user.ID).And("w.id = ?", workoutID).
-			Join("exercises AS e").On("e.workout = w.id").
-			Join("sets AS s").On("s.exercise = e.id").
-			OrderBy("w.id", "e.id", "s.order")
-		row := struct {
-			WorkoutID    uint64 `db:"workout_id"`
-			ExerciseID   uint64 `db:"exercise_id"`
-			SetID        uint64 `db:"set_id"`
-			WorkoutName  string `db:"workout_name"`
-			ExerciseName string `db:"exercise_name"`
-			WorkoutDB    `db:",inline"`
-			ExerciseDB   `db:",inline"`
-			SetDB        `db:",inline"`
-		}{}
-		iter := q.Iterator()
-		defer iter.Close()
-		workout := Workout{}
-		count := 0
-		for iter.Next(&row) {
-
-			count++
+	authed.GET("/workout/:id", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid workout ID.")
+			return
+		}
+		workout, err := store.LoadWorkout(db, currentUser(c).ID, workoutID)
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "No workout matching that ID.")
+			return
 		}
-		if err = iter.Err(); err != nil {
+		if err != nil {
 			c.String(http.StatusInternalServerError, "Error reading workout. "+err.Error())
 			return
 		}
-		if count == 0 {
-			c.String(http.StatusBadRequest, "No workout matching that ID. "+err.Error())
+		if !workout.IsTemplate() {
+			workout.StartTimeStr = time.Unix(int64(workout.StartTime), 0).Format(timeFormat)
+		}
+		if workout.EndTime != 0 {
+			workout.EndTimeStr = time.Unix(int64(workout.EndTime), 0).Format(timeFormat)
+		}
+		catalog, err := store.Catalog(db)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error reading exercises. "+err.Error())
+			return
+		}
+		var rest *store.RestTimerDB
+		if timer, err := store.RestTimer(db, currentUser(c).ID, workoutID); err == nil && timer.Running() {
+			rest = &timer
+		} else if err != nil && err != store.ErrNoRestTimer {
+			c.String(http.StatusInternalServerError, "Error reading rest timer. "+err.Error())
 			return
 		}
-		c.HTML(http.StatusOK, "workout.tmpl", workout)
+		c.HTML(http.StatusOK, "workout.tmpl", struct {
+			store.Workout
+			Catalog []store.ExerciseDefinitionDB
+			Rest    *store.RestTimerDB // nil unless resting
+			Unit    string
+		}{workout.ToUnit(currentUser(c).Unit), catalog, rest, currentUser(c).Unit})
 	})
 
-	router.GET("/deleteWorkout/:id", func(c *gin.Context) {
-		workoutID, err := strconv.Atoi(c.Param("id"))
+	authed.GET("/deleteWorkout/:id", func(c *gin.Context) {
+		workoutID, err := idParam(c, "id")
 		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid workout ID.")
 			return
 		}
-		userCookie, err := c.Cookie("user_id")
-		if err != nil {
-			c.Redirect(http.StatusSeeOther, "/login")
+		workout, err := store.UserWorkout(db, currentUser(c).ID, workoutID)
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "No workout matching that ID.")
 			return
 		}
-		user := UserDB{}
-		err = db.Collection("users").Find(up.Cond{"cookie": userCookie}).One(&user)
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Error reading user info. Your user cookie may be invalid. "+err.Error())
+			c.String(http.StatusInternalServerError, "Error reading workout session. "+err.Error())
 			return
 		}
 		err = db.Collection("workouts").Find(workoutID).Delete()
@@ -387,11 +808,15 @@ func main() {
 			c.String(http.StatusInternalServerError, "Error deleting workout session. "+err.Error())
 			return
 		}
+		if workout.IsTemplate() {
+			c.Redirect(http.StatusSeeOther, "/templates")
+			return
+		}
 		c.Redirect(http.StatusSeeOther, "/")
 	})
 
-	router.GET("/admin/users", func(c *gin.Context) {
-		var users []UserDB
+	admin.GET("/admin/users", func(c *gin.Context) {
+		var users []store.UserDB
 		err := db.Collection("users").Find().All(&users)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error reading users. "+err.Error())
@@ -400,18 +825,71 @@ func main() {
 		c.HTML(http.StatusOK, "admin_users.tmpl", users)
 	})
 
-	router.GET("/admin/exercises", func(c *gin.Context) {
-		var exercises []ExerciseDB
-		err := db.Collection("exercises").Find().All(&exercises)
+	admin.GET("/admin/exercises", func(c *gin.Context) {
+		catalog, err := store.Catalog(db)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error reading exercises. "+err.Error())
 			return
 		}
-		c.HTML(http.StatusOK, "admin_exercises.tmpl", exercises)
+		c.HTML(http.StatusOK, "admin_exercises.tmpl", struct {
+			Catalog []store.ExerciseDefinitionDB
+			Types   []string
+		}{catalog, store.SetTypes})
 	})
 
-	router.GET("/admin/workouts", func(c *gin.Context) {
-		var workouts []WorkoutDB
+	admin.GET("/admin/exercise/:id", func(c *gin.Context) {
+		id, err := idParam(c, "id")
+		if err != nil {
+			c.String(http.StatusBadRequest, "Error bad exercise id. "+err.Error())
+			return
+		}
+		var def store.ExerciseDefinitionDB
+		err = db.Collection("exercise_definitions").Find(id).One(&def)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error reading exercise. "+err.Error())
+			return
+		}
+		c.HTML(http.StatusOK, "admin_exercise_edit.tmpl", struct {
+			store.ExerciseDefinitionDB
+			Progressions []string
+			Unit         string
+		}{def.ToUnit(currentUser(c).Unit), store.Progressions(), currentUser(c).Unit})
+	})
+
+	// body: {"id": catalog exercise ID, plus any fields of store.ProgressionPatch}
+	admin.POST("/json/updateExerciseProgression", func(c *gin.Context) {
+		var req struct {
+			ID uint64 `json:"id"`
+			store.ProgressionPatch
+		}
+		c.MustBindWith(&req, binding.JSON)
+		req.ProgressionPatch = req.ProgressionPatch.FromUnit(currentUser(c).Unit)
+		detail, _ := json.Marshal(req.ProgressionPatch)
+		var def store.ExerciseDefinitionDB
+		err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			var err error
+			if def, err = store.UpdateProgression(tx, req.ID, req.ProgressionPatch); err != nil {
+				return err
+			}
+			return store.Audit(tx, currentUser(c).ID, "update", "exercise_definitions", req.ID, string(detail))
+		})
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			c.String(http.StatusNotFound, "Couldn't update exercise. No exercise matching that ID.")
+			return
+		case store.ErrBadProgression:
+			c.String(http.StatusBadRequest, "Couldn't update exercise. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Couldn't update exercise. "+err.Error())
+			return
+		}
+		c.JSON(http.StatusOK, def.ToUnit(currentUser(c).Unit))
+	})
+
+	admin.GET("/admin/workouts", func(c *gin.Context) {
+		var workouts []store.WorkoutDB
 		err := db.Collection("workouts").Find().All(&workouts)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error reading workouts. "+err.Error())
@@ -420,76 +898,192 @@ func main() {
 		c.HTML(http.StatusOK, "admin_workouts.tmpl", workouts)
 	})
 
-	router.GET("/admin/set/:id", func(c *gin.Context) {
+	admin.GET("/admin/set/:id", func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			c.String(http.StatusBadRequest, "Error bad set id. "+err.Error())
 			return
 		}
-		var set SetDB
+		var set store.SetDB
 		err = db.Collection("sets").Find(id).One(&set)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error reading set. "+err.Error())
 			return
 		}
-		c.HTML(http.StatusOK, "admin_set_edit.tmpl", set)
+		c.HTML(http.StatusOK, "admin_set_edit.tmpl", struct {
+			store.SetDB
+			Unit  string
+			Types []string
+		}{set.ToUnit(currentUser(c).Unit), currentUser(c).Unit, store.SetTypes})
 	})
 
-	router.GET("/admin/workout/:id", func(c *gin.Context) {
+	admin.GET("/admin/workout/:id", func(c *gin.Context) {
 		idStr := c.Param("id")
 		id, err := strconv.Atoi(idStr)
 		if err != nil {
 			c.String(http.StatusBadRequest, "Error bad workout id. "+err.Error())
 			return
 		}
-		var workout WorkoutDB
+		var workout store.WorkoutDB
 		err = db.Collection("workouts").Find(id).One(&workout)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error reading workouts. "+err.Error())
 			return
 		}
-		var sets []SetDB
-		err = db.Collection("sets").Find(up.Cond{"workout": id}).All(&sets)
+		full, err := store.LoadWorkout(db, workout.User, workout.ID)
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Error reading sets. "+err.Error())
 			return
 		}
 		data := struct {
-			WorkoutDB
-			Sets []SetDB
+			store.WorkoutDB
+			Sets []store.SetDB
 		}{}
 		data.WorkoutDB = workout
-		data.Sets = sets
+		for _, e := range full.Exercises {
+			data.Sets = append(data.Sets, e.Sets...)
+		}
 		c.HTML(http.StatusOK, "admin_workout_edit.tmpl", data)
 	})
 
-	router.POST("/json/addUser", func(c *gin.Context) {
-		buf := &bytes.Buffer{}
-		buf.ReadFrom(c.Request.Body)
-		user := UserDB{
-			Name:     buf.String(),
-			Password: "",
+	// body: {"id": workout ID, plus any fields of store.WorkoutPatch}
+	admin.POST("/json/updateWorkout", func(c *gin.Context) {
+		var req struct {
+			ID uint64 `json:"id"`
+			store.WorkoutPatch
 		}
-		_, err := db.Collection("users").Insert(user)
-		if err != nil {
-			c.String(http.StatusInternalServerError, "Couldn't add new user."+err.Error())
+		if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+			c.String(http.StatusBadRequest, "Invalid workout update. "+err.Error())
+			return
+		}
+		detail, _ := json.Marshal(req.WorkoutPatch)
+		var workout store.WorkoutDB
+		err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			owner, err := store.WorkoutOwner(tx, req.ID)
+			if err != nil {
+				return err
+			}
+			if workout, err = store.UpdateWorkout(tx, owner, req.ID, req.WorkoutPatch); err != nil {
+				return err
+			}
+			return store.Audit(tx, currentUser(c).ID, "update", "workouts", req.ID, string(detail))
+		})
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			c.String(http.StatusNotFound, "Couldn't update workout. No workout matching that ID.")
+			return
+		case store.ErrBadWorkoutName, store.ErrBadTimes, store.ErrFinishTemplate, store.ErrTemplateSession:
+			c.String(http.StatusBadRequest, "Couldn't update workout. "+err.Error())
+			return
+		case store.ErrWorkoutFinished:
+			c.String(http.StatusConflict, "Couldn't update workout. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Couldn't update workout. "+err.Error())
+			return
+		}
+		live.publish(workout.ID, eventWorkoutUpdated, workout)
+		c.JSON(http.StatusOK, workout)
+	})
+
+	// body: {"id": set ID, plus any fields of store.SetPatch}
+	admin.POST("/json/updateSet", func(c *gin.Context) {
+		var req struct {
+			ID uint64 `json:"id"`
+			store.SetPatch
+		}
+		if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+			c.String(http.StatusBadRequest, "Invalid set update. "+err.Error())
+			return
+		}
+		req.SetPatch = req.SetPatch.FromUnit(currentUser(c).Unit)
+		detail, _ := json.Marshal(req.SetPatch)
+		var owner uint64
+		var set store.SetDB
+		err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			var err error
+			if owner, err = store.SetOwner(tx, req.ID); err != nil {
+				return err
+			}
+			if set, err = store.UpdateSet(tx, owner, req.ID, req.SetPatch); err != nil {
+				return err
+			}
+			return store.Audit(tx, currentUser(c).ID, "update", "sets", req.ID, string(detail))
+		})
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			c.String(http.StatusNotFound, "Couldn't update set. No set matching that ID.")
+			return
+		case store.ErrNegativeSet, store.ErrTemplateActuals, store.ErrBadSetType, store.ErrRepTarget, store.ErrDropParent:
+			c.String(http.StatusBadRequest, "Couldn't update set. "+err.Error())
+			return
+		case store.ErrWorkoutFinished, store.ErrHasDropSets:
+			c.String(http.StatusConflict, "Couldn't update set. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Couldn't update set. "+err.Error())
+			return
+		}
+		live.publishSet(db, owner, eventSetUpdated, set)
+		c.JSON(http.StatusOK, set.ToUnit(currentUser(c).Unit))
+	})
+
+	admin.POST("/json/addUser", func(c *gin.Context) {
+		var req struct {
+			Name     string `json:"name"`
+			Password string `json:"password"`
+		}
+		if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+			c.String(http.StatusBadRequest, "Invalid new user. "+err.Error())
+			return
+		}
+		user, err := store.CreateUser(db, req.Name, req.Password)
+		if err == nil {
+			err = store.Audit(db, currentUser(c).ID, "add", "users", user.ID, user.Name)
+		}
+		switch err {
+		case nil:
+		case store.ErrBadUserName, store.ErrWeakPassword, store.ErrUserExists:
+			c.String(http.StatusBadRequest, "Couldn't add new user. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Couldn't add new user. "+err.Error())
 			return
 		}
 		c.String(http.StatusOK, user.Name)
 	})
 
-	router.POST("/json/removeUser", func(c *gin.Context) {
-		// todo: remove all workouts and sets associated with the user
+	admin.POST("/json/removeUser", func(c *gin.Context) {
+		// the user's workouts, exercises, sets and sessions go with it (ON DELETE CASCADE)
 		buf := &bytes.Buffer{}
 		buf.ReadFrom(c.Request.Body)
 		s := buf.String()
-		userID, err := strconv.Atoi(s)
+		userID, err := strconv.ParseUint(s, 10, 64)
 		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid id for user to remove. "+err.Error())
 			return
 		}
-		err = db.Collection("users").Find(userID).Delete()
+		err = db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			res := tx.Collection("users").Find(userID)
+			exists, err := res.Exists()
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return store.ErrNotFound
+			}
+			if err := res.Delete(); err != nil {
+				return err
+			}
+			return store.Audit(tx, currentUser(c).ID, "remove", "users", userID, "")
+		})
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "Couldn't remove user. No user matching that ID.")
+			return
+		}
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Couldn't remove user. "+err.Error())
 			return
@@ -497,57 +1091,190 @@ func main() {
 		c.String(http.StatusOK, "removed user with id: "+s)
 	})
 
-	router.POST("/json/addExercise", func(c *gin.Context) {
-		var exercise ExerciseDB
-		c.MustBindWith(&exercise, binding.JSON)
-		_, err := db.Collection("exercises").Insert(exercise)
+	admin.POST("/json/setRole", func(c *gin.Context) {
+		var req struct {
+			User uint64 `json:"user"`
+			Role string `json:"role"`
+		}
+		if err := c.ShouldBindWith(&req, binding.JSON); err != nil {
+			c.String(http.StatusBadRequest, "Invalid role change. "+err.Error())
+			return
+		}
+		err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			if err := store.SetRole(tx, req.User, req.Role); err != nil {
+				return err
+			}
+			return store.Audit(tx, currentUser(c).ID, "setRole", "users", req.User, req.Role)
+		})
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "Couldn't change role. No user matching that ID.")
+			return
+		}
 		if err != nil {
-			c.String(http.StatusInternalServerError, "Couldn't add new exercise."+err.Error())
+			c.String(http.StatusBadRequest, "Couldn't change role. "+err.Error())
 			return
 		}
-		c.String(http.StatusOK, exercise.Name)
+		c.String(http.StatusOK, req.Role)
 	})
 
-	router.POST("/json/removeExercise", func(c *gin.Context) {
+	admin.GET("/admin/audit", func(c *gin.Context) {
+		entries, err := store.AuditLog(db, 200)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "Error reading audit log. "+err.Error())
+			return
+		}
+		for i := range entries {
+			entries[i].TimeStr = time.Unix(entries[i].Time, 0).Format(timeFormat)
+		}
+		c.HTML(http.StatusOK, "admin_audit.tmpl", entries)
+	})
+
+	admin.POST("/json/addExerciseDefinition", func(c *gin.Context) {
+		var def store.ExerciseDefinitionDB
+		c.MustBindWith(&def, binding.JSON)
+		def.Increment = def.Increment.FromUnit(currentUser(c).Unit)
+		err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			var err error
+			if def, err = store.AddDefinition(tx, def); err != nil {
+				return err
+			}
+			return store.Audit(tx, currentUser(c).ID, "add", "exercise_definitions", def.ID, def.Name)
+		})
+		switch err {
+		case nil:
+		case store.ErrBadExerciseName, store.ErrBadDefaults, store.ErrBadProgression, store.ErrExerciseExists,
+			store.ErrBadSetType, store.ErrRepTarget, store.ErrDropParent:
+			c.String(http.StatusBadRequest, "Couldn't add exercise to catalog. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Couldn't add exercise to catalog. "+err.Error())
+			return
+		}
+		c.String(http.StatusOK, def.Name)
+	})
+
+	admin.POST("/json/removeExerciseDefinition", func(c *gin.Context) {
 		buf := &bytes.Buffer{}
 		buf.ReadFrom(c.Request.Body)
 		s := buf.String()
-		exerciseID, err := strconv.Atoi(s)
+		definitionID, err := strconv.ParseUint(s, 10, 64)
 		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid id for exercise to remove. "+err.Error())
 			return
 		}
-		err = db.Collection("exercises").Find(exerciseID).Delete()
+		err = db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			if err := store.RemoveDefinition(tx, definitionID); err != nil {
+				return err
+			}
+			return store.Audit(tx, currentUser(c).ID, "remove", "exercise_definitions", definitionID, "")
+		})
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			c.String(http.StatusNotFound, "Couldn't remove exercise from catalog. No exercise matching that ID.")
+			return
+		case store.ErrExerciseInUse:
+			c.String(http.StatusConflict, "Couldn't remove exercise from catalog. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Couldn't remove exercise from catalog. "+err.Error())
+			return
+		}
+		c.String(http.StatusOK, "removed exercise with id: "+s)
+	})
+
+	// adds an instance of a catalog exercise, with the catalog's default sets, to a workout
+	authed.POST("/json/addExercise", func(c *gin.Context) {
+		var req store.ExerciseDB
+		c.MustBindWith(&req, binding.JSON)
+		exercise, err := store.AddExercise(db, currentUser(c).ID, req.Workout, req.Definition, req.Notes)
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			c.String(http.StatusNotFound, "Couldn't add new exercise. No workout or catalog exercise matching that ID.")
+			return
+		case store.ErrWorkoutFinished:
+			c.String(http.StatusConflict, "Couldn't add new exercise. "+err.Error())
+			return
+		default:
+			c.String(http.StatusInternalServerError, "Couldn't add new exercise. "+err.Error())
+			return
+		}
+		live.publish(exercise.Workout, eventExerciseAdded, exercise)
+		c.String(http.StatusOK, exercise.Name)
+	})
+
+	authed.POST("/json/removeExercise", func(c *gin.Context) {
+		buf := &bytes.Buffer{}
+		buf.ReadFrom(c.Request.Body)
+		s := buf.String()
+		exerciseID, err := strconv.ParseUint(s, 10, 64)
 		if err != nil {
+			c.String(http.StatusBadRequest, "Invalid id for exercise to remove. "+err.Error())
+			return
+		}
+		exercise, err := store.RemoveExercise(db, currentUser(c).ID, exerciseID)
+		switch err {
+		case nil:
+		case store.ErrNotFound:
+			c.String(http.StatusNotFound, "Couldn't remove exercise. No exercise matching that ID.")
+			return
+		case store.ErrWorkoutFinished:
+			c.String(http.StatusConflict, "Couldn't remove exercise. "+err.Error())
+			return
+		default:
 			c.String(http.StatusInternalServerError, "Couldn't remove exercise. "+err.Error())
 			return
 		}
+		live.publish(exercise.Workout, eventExerciseRemoved, gin.H{"id": exercise.ID})
 		c.String(http.StatusOK, "removed exercise with id: "+s)
 	})
 
-	router.POST("/json/addWorkout", func(c *gin.Context) {
-		var workout WorkoutDB
+	authed.POST("/json/addWorkout", func(c *gin.Context) {
+		var workout store.WorkoutDB
 		c.MustBindWith(&workout, binding.JSON)
-		_, err := db.Collection("workouts").Insert(workout)
-		if err != nil {
+		workout, err := store.AddWorkout(db, currentUser(c).ID, workout)
+		switch err {
+		case nil:
+		case store.ErrBadWorkoutName, store.ErrFinishTemplate, store.ErrBadTimes:
+			c.String(http.StatusBadRequest, "Couldn't add new workout. "+err.Error())
+			return
+		default:
 			c.String(http.StatusInternalServerError, "Couldn't add new workout. "+err.Error())
 			return
 		}
 		c.String(http.StatusOK, workout.Name)
 	})
 
-	router.POST("/json/removeWorkout", func(c *gin.Context) {
+	admin.POST("/json/removeWorkout", func(c *gin.Context) {
 		buf := &bytes.Buffer{}
 		buf.ReadFrom(c.Request.Body)
 		s := buf.String()
 
-		// todo: also remove any sets associated with the workout
-		workoutID, err := strconv.Atoi(s)
+		// the workout's exercises and sets go with it (ON DELETE CASCADE)
+		workoutID, err := strconv.ParseUint(s, 10, 64)
 		if err != nil {
 			c.String(http.StatusBadRequest, "Invalid id for workout to remove. "+err.Error())
 			return
 		}
-		err = db.Collection("workouts").Find(workoutID).Delete()
+		err = db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+			res := tx.Collection("workouts").Find(workoutID)
+			exists, err := res.Exists()
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return store.ErrNotFound
+			}
+			if err := res.Delete(); err != nil {
+				return err
+			}
+			return store.Audit(tx, currentUser(c).ID, "remove", "workouts", workoutID, "")
+		})
+		if err == store.ErrNotFound {
+			c.String(http.StatusNotFound, "Couldn't remove workout. No workout matching that ID.")
+			return
+		}
 		if err != nil {
 			c.String(http.StatusInternalServerError, "Couldn't remove workouts. "+err.Error())
 			return
@@ -555,5 +1282,5 @@ func main() {
 		c.String(http.StatusOK, "removed workout with id: "+s)
 	})
 
-	router.Run(":" + port)
+	return router
 }
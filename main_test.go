@@ -0,0 +1,184 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BrianWill/WorkoutTracker/store"
+	"github.com/gin-gonic/gin"
+	up "upper.io/db.v3"
+)
+
+// recorder records a response for a client that has already gone, so a live
+// event stream ends at once rather than keeping the test waiting.
+type recorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (recorder) CloseNotify() <-chan bool {
+	gone := make(chan bool, 1)
+	gone <- true
+	return gone
+}
+
+// TestOtherUsersRecords has user B go after the workout, exercise and set of
+// user A through every route that takes their IDs. Each must answer 404, as
+// if the records didn't exist, and leave them as they were.
+func TestOtherUsersRecords(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir, err := ioutil.TempDir("", "workouttracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := store.OpenSQLite(filepath.Join(dir, store.SQLiteFilePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := store.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.SeedExercises(db); err != nil {
+		t.Fatal(err)
+	}
+	var squat store.ExerciseDefinitionDB
+	if err := db.Collection("exercise_definitions").Find(up.Cond{"name": "Squat"}).One(&squat); err != nil {
+		t.Fatal(err)
+	}
+
+	// each user has a session in progress with one exercise
+	type account struct {
+		user     store.UserDB
+		token    string
+		workout  store.WorkoutDB
+		exercise store.Exercise
+	}
+	var a, b account
+	for _, acc := range []struct {
+		*account
+		name string
+	}{{&a, "alice"}, {&b, "bob"}} {
+		if acc.user, err = store.CreateUser(db, acc.name, "password1"); err != nil {
+			t.Fatal(err)
+		}
+		if acc.token, err = store.NewSession(db, acc.user.ID, "test"); err != nil {
+			t.Fatal(err)
+		}
+		workout := store.WorkoutDB{Name: "Monday", StartTime: uint64(time.Now().Unix())}
+		if acc.workout, err = store.AddWorkout(db, acc.user.ID, workout); err != nil {
+			t.Fatal(err)
+		}
+		if acc.exercise, err = store.AddExercise(db, acc.user.ID, acc.workout.ID, squat.ID, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := store.LoadWorkout(db, a.user.ID, a.workout.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := newRouter(db)
+	id := func(id uint64) string { return strconv.FormatUint(id, 10) }
+	workout, exercise, set := id(a.workout.ID), id(a.exercise.ID), id(a.exercise.Sets[0].ID)
+	apiExercise := "/api/v1/workouts/" + workout + "/exercises/" + exercise
+	apiSet := apiExercise + "/sets/" + set
+	// B's own workout and exercise, with A's exercise or set in the path
+	ownWorkout := "/api/v1/workouts/" + id(b.workout.ID)
+	ownExercise := ownWorkout + "/exercises/" + id(b.exercise.ID)
+	for _, r := range []struct {
+		method, path, body string
+	}{
+		{"GET", "/workout/" + workout, ""},
+		{"GET", "/createWorkout/" + workout, ""},
+		{"POST", "/renameWorkout/" + workout, "name=mine"},
+		{"POST", "/addExercise/" + workout, "definition=" + id(squat.ID)},
+		{"GET", "/workout/" + workout + "/events", ""},
+		{"POST", "/workout/" + workout + "/start", ""},
+		{"POST", "/workout/" + workout + "/finish", ""},
+		{"POST", "/workout/" + workout + "/startSet/" + set, ""},
+		{"POST", "/workout/" + workout + "/completeSet/" + set, ""},
+		{"POST", "/workout/" + id(b.workout.ID) + "/startSet/" + set, ""},
+		{"POST", "/json/addExercise", `{"workout": ` + workout + `, "definition": ` + id(squat.ID) + `}`},
+		{"POST", "/json/removeExercise", exercise},
+
+		{"GET", "/api/v1/workouts/" + workout, ""},
+		{"PATCH", "/api/v1/workouts/" + workout, `{"name": "mine"}`},
+		{"POST", "/api/v1/workouts/" + workout + "/start", ""},
+		{"POST", "/api/v1/workouts/" + workout + "/finish", ""},
+		{"GET", "/api/v1/workouts/" + workout + "/rest", ""},
+		{"GET", "/api/v1/workouts/" + workout + "/exercises", ""},
+		{"POST", "/api/v1/workouts/" + workout + "/exercises", `{"definition": ` + id(squat.ID) + `}`},
+		{"GET", apiExercise, ""},
+		{"PATCH", apiExercise, `{"notes": "mine"}`},
+		{"DELETE", apiExercise, ""},
+		{"GET", apiExercise + "/sets", ""},
+		{"POST", apiExercise + "/sets", `{"repsExpected": 5}`},
+		{"GET", apiSet, ""},
+		{"PATCH", apiSet, `{"reps": 1}`},
+		{"DELETE", apiSet, ""},
+		{"POST", apiSet + "/start", ""},
+		{"POST", apiSet + "/complete", ""},
+		{"GET", ownWorkout + "/exercises/" + exercise, ""},
+		{"DELETE", ownWorkout + "/exercises/" + exercise, ""},
+		{"GET", ownExercise + "/sets/" + set, ""},
+		{"PATCH", ownExercise + "/sets/" + set, `{"reps": 1}`},
+		{"DELETE", ownExercise + "/sets/" + set, ""},
+		// last, as they would take the rest along if they went through
+		{"GET", "/deleteWorkout/" + workout, ""},
+		{"DELETE", "/api/v1/workouts/" + workout, ""},
+	} {
+		req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+		switch {
+		case strings.HasPrefix(r.body, "{"):
+			req.Header.Set("Content-Type", "application/json")
+		case r.body != "" && strings.Contains(r.body, "="):
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		if strings.HasPrefix(r.path, "/api/") {
+			req.Header.Set("Authorization", "Bearer "+b.token)
+		} else {
+			req.AddCookie(&http.Cookie{Name: sessionCookie, Value: b.token})
+		}
+		w := recorder{httptest.NewRecorder()}
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusNotFound {
+			body := strings.SplitN(w.Body.String(), "\n", 2)[0]
+			t.Errorf("%s %s as another user: %d %s, want 404", r.method, r.path, w.Code, body)
+		}
+	}
+
+	after, err := store.LoadWorkout(db, a.user.ID, a.workout.ID)
+	if err != nil {
+		t.Fatalf("workout of A after B's requests: %s", err)
+	}
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("workout of A changed by B's requests:\nbefore %+v\nafter  %+v", before, after)
+	}
+	for _, user := range []store.UserDB{a.user, b.user} {
+		n, err := db.Collection("workouts").Find(up.Cond{"user": user.ID}).Count()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("%s has %d workouts, want 1", user.Name, n)
+		}
+	}
+
+	// the same request succeeds for A, so the 404s above are about ownership
+	req := httptest.NewRequest("GET", apiSet, nil)
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("GET %s as its owner: %d %s, want 200", apiSet, w.Code, w.Body)
+	}
+}
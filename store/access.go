@@ -0,0 +1,178 @@
+package store
+
+import (
+	"errors"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// Conn is satisfied by both an open database and a transaction on it.
+type Conn interface {
+	up.Database
+	sqlbuilder.SQLBuilder
+}
+
+// ErrNotFound is returned when a record doesn't exist or doesn't belong to
+// the user asking for it. The two cases are indistinguishable on purpose, so
+// a user can't probe for the IDs of other users' records.
+var ErrNotFound = errors.New("no such record")
+
+// UserWorkout returns the workout if it belongs to the user.
+func UserWorkout(db Conn, userID, workoutID uint64) (WorkoutDB, error) {
+	var workout WorkoutDB
+	err := db.Collection("workouts").Find(up.Cond{"id": workoutID, "user": userID}).One(&workout)
+	if err == up.ErrNoMoreRows {
+		return WorkoutDB{}, ErrNotFound
+	}
+	return workout, err
+}
+
+// UserExercise returns the exercise if it is part of one of the user's workouts.
+func UserExercise(db Conn, userID, exerciseID uint64) (ExerciseDB, error) {
+	var exercise ExerciseDB
+	err := db.Collection("workout_exercises").Find(exerciseID).One(&exercise)
+	if err == up.ErrNoMoreRows {
+		return ExerciseDB{}, ErrNotFound
+	}
+	if err != nil {
+		return ExerciseDB{}, err
+	}
+	if _, err := UserWorkout(db, userID, exercise.Workout); err != nil {
+		return ExerciseDB{}, err
+	}
+	return exercise, nil
+}
+
+// UserSet returns the set if it is part of one of the user's workouts.
+func UserSet(db Conn, userID, setID uint64) (SetDB, error) {
+	var set SetDB
+	err := db.Collection("sets").Find(setID).One(&set)
+	if err == up.ErrNoMoreRows {
+		return SetDB{}, ErrNotFound
+	}
+	if err != nil {
+		return SetDB{}, err
+	}
+	if _, err := UserExercise(db, userID, set.Exercise); err != nil {
+		return SetDB{}, err
+	}
+	return set, nil
+}
+
+// WorkoutOwner returns the ID of the user the workout belongs to.
+func WorkoutOwner(db Conn, workoutID uint64) (uint64, error) {
+	var workout WorkoutDB
+	err := db.Collection("workouts").Find(workoutID).One(&workout)
+	if err == up.ErrNoMoreRows {
+		return 0, ErrNotFound
+	}
+	return workout.User, err
+}
+
+// SetOwner returns the ID of the user whose workout the set is part of.
+func SetOwner(db Conn, setID uint64) (uint64, error) {
+	var set SetDB
+	err := db.Collection("sets").Find(setID).One(&set)
+	if err == up.ErrNoMoreRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	var exercise ExerciseDB
+	err = db.Collection("workout_exercises").Find(set.Exercise).One(&exercise)
+	if err == up.ErrNoMoreRows {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return WorkoutOwner(db, exercise.Workout)
+}
+
+// LoadWorkout assembles the user's workout with all of its exercises, named
+// from the catalog, and their sets with the personal records they hold.
+// Exercises are in the order they were added, sets by Order.
+func LoadWorkout(db Conn, userID, workoutID uint64) (Workout, error) {
+	w, err := UserWorkout(db, userID, workoutID)
+	if err != nil {
+		return Workout{}, err
+	}
+	workouts, err := assembleWorkouts(db, []WorkoutDB{w})
+	if err != nil {
+		return Workout{}, err
+	}
+	workout := workouts[0]
+	if w.IsTemplate() {
+		return workout, nil
+	}
+	records := map[uint64][]string{}
+	for _, e := range workout.Exercises {
+		bySet, err := setRecords(db, userID, e.Definition)
+		if err != nil {
+			return Workout{}, err
+		}
+		for id, kinds := range bySet {
+			records[id] = kinds
+		}
+	}
+	for _, e := range workout.Exercises {
+		for i := range e.Sets {
+			e.Sets[i].Records = records[e.Sets[i].ID]
+		}
+	}
+	return workout, nil
+}
+
+// assembleWorkouts loads the exercises, named from the catalog, and the sets
+// of the workouts, in the same order as LoadWorkout but without records.
+func assembleWorkouts(db Conn, workoutDBs []WorkoutDB) ([]Workout, error) {
+	workouts := make([]Workout, len(workoutDBs))
+	workoutIDs := make([]uint64, len(workoutDBs))
+	byWorkout := make(map[uint64]int, len(workoutDBs))
+	for i, w := range workoutDBs {
+		workouts[i] = Workout{WorkoutDB: w, Exercises: []Exercise{}}
+		workoutIDs[i] = w.ID
+		byWorkout[w.ID] = i
+	}
+	if len(workoutIDs) == 0 {
+		return workouts, nil
+	}
+	var exercises []ExerciseDB
+	err := db.Collection("workout_exercises").Find(up.Cond{"workout IN": workoutIDs}).OrderBy("id").All(&exercises)
+	if err != nil {
+		return nil, err
+	}
+	if len(exercises) == 0 {
+		return workouts, nil
+	}
+	ids := make([]uint64, len(exercises))
+	definitionIDs := make([]uint64, len(exercises))
+	for i, e := range exercises {
+		ids[i] = e.ID
+		definitionIDs[i] = e.Definition
+	}
+	definitions, err := definitionsByID(db, definitionIDs)
+	if err != nil {
+		return nil, err
+	}
+	type position struct{ workout, exercise int }
+	byID := make(map[uint64]position, len(exercises))
+	for _, e := range exercises {
+		w := &workouts[byWorkout[e.Workout]]
+		byID[e.ID] = position{byWorkout[e.Workout], len(w.Exercises)}
+		w.Exercises = append(w.Exercises, Exercise{ExerciseDB: e, Name: definitions[e.Definition].Name, Sets: []SetDB{}})
+	}
+	var sets []SetDB
+	err = db.Collection("sets").Find(up.Cond{"exercise IN": ids}).OrderBy("exercise", "order").All(&sets)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sets {
+		p := byID[s.Exercise]
+		e := &workouts[p.workout].Exercises[p.exercise]
+		e.Sets = append(e.Sets, s)
+	}
+	return workouts, nil
+}
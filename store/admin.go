@@ -0,0 +1,61 @@
+package store
+
+import (
+	"fmt"
+	"time"
+
+	up "upper.io/db.v3"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// AuditEntryDB records a change an admin made to some record.
+type AuditEntryDB struct {
+	ID       uint64 `db:"id,omitempty"`
+	Admin    uint64 `db:"admin"`  // ID of the admin user
+	Action   string `db:"action"` // e.g. "add", "remove"
+	Table    string `db:"table"`
+	RecordID uint64 `db:"recordID"`
+	Detail   string `db:"detail"`
+	Time     int64  `db:"time"` // unix time
+	TimeStr  string `db:"-"`
+}
+
+// Audit records that the admin performed action on a record of table.
+func Audit(db Conn, adminID uint64, action, table string, recordID uint64, detail string) error {
+	_, err := db.Collection("audit_log").Insert(AuditEntryDB{
+		Admin:    adminID,
+		Action:   action,
+		Table:    table,
+		RecordID: recordID,
+		Detail:   detail,
+		Time:     time.Now().Unix(),
+	})
+	return err
+}
+
+// AuditLog returns the most recent audit entries, newest first.
+func AuditLog(db Conn, limit int) ([]AuditEntryDB, error) {
+	var entries []AuditEntryDB
+	err := db.Collection("audit_log").Find().OrderBy("-id").Limit(limit).All(&entries)
+	return entries, err
+}
+
+// SetRole changes the role of the user.
+func SetRole(db Conn, userID uint64, role string) error {
+	if role != RoleUser && role != RoleAdmin {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	res := db.Collection("users").Find(userID)
+	exists, err := res.Exists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return res.Update(up.Cond{"role": role})
+}
@@ -0,0 +1,318 @@
+package store
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	up "upper.io/db.v3"
+)
+
+// Metrics the analytics are computed for. The metrics of sets leave out warm-ups.
+const (
+	MetricTonnage   = "tonnage"   // sum of reps × weight
+	MetricSets      = "sets"      // number of sets done
+	MetricIntensity = "intensity" // average weight of the sets as a percentage of the estimated one-rep max at the time
+	MetricOneRepMax = "1rm"       // best one-rep max estimated with Epley's formula from the sets
+	MetricDuration  = "duration"  // seconds from start to end of finished sessions of known duration
+	MetricSessions  = "sessions"  // number of sessions
+)
+
+// How the sessions of a query are grouped in time. Periods are in UTC and
+// weeks start on Monday.
+const (
+	ByWorkout = "workout"
+	ByDay     = "day"
+	ByWeek    = "week"
+	ByMonth   = "month"
+)
+
+// How the values of a query are split into series.
+const (
+	PerTotal    = "total"    // one series for everything
+	PerExercise = "exercise" // a series per catalog exercise
+	PerMuscle   = "muscle"   // a series per muscle group; a set counts for each group its exercise works
+)
+
+var ErrBadQuery = errors.New("unknown metric, grouping or split, or a split or exercise filter the metric doesn't have")
+
+// AnalyticsQuery selects what Analytics computes: a metric over the sessions
+// started in [From, To), grouped in time By and split Per. A Definition
+// limits the metrics of sets to the sets of that catalog exercise. Weights
+// are in Unit, kilograms if empty.
+type AnalyticsQuery struct {
+	Metric     string
+	From       time.Time
+	To         time.Time
+	By         string
+	Per        string
+	Definition uint64
+	Unit       string
+}
+
+// Point is the value of a metric for one period, or one session when grouped by workout.
+type Point struct {
+	Time    int64   `json:"time"`              // unix time of the start of the period or session
+	Workout uint64  `json:"workout,omitempty"` // the session, when grouped by workout
+	Value   float64 `json:"value"`
+}
+
+// Series is the values of a metric over time for the whole, an exercise or a muscle group.
+type Series struct {
+	Name       string  `json:"name"`                 // "total", the exercise or the muscle group
+	Definition uint64  `json:"definition,omitempty"` // the catalog exercise of a per exercise series
+	Points     []Point `json:"points"`               // by time, only periods with data
+}
+
+func (q AnalyticsQuery) valid() error {
+	switch q.Metric {
+	case MetricTonnage, MetricSets, MetricIntensity, MetricOneRepMax:
+	case MetricDuration, MetricSessions: // of whole sessions
+		if q.Per != PerTotal || q.Definition != 0 {
+			return ErrBadQuery
+		}
+	default:
+		return ErrBadQuery
+	}
+	switch q.By {
+	case ByWorkout, ByDay, ByWeek, ByMonth:
+	default:
+		return ErrBadQuery
+	}
+	switch q.Per {
+	case PerTotal, PerExercise, PerMuscle:
+	default:
+		return ErrBadQuery
+	}
+	if !q.From.Before(q.To) {
+		return ErrBadQuery
+	}
+	return nil
+}
+
+// period returns the start of the period the time falls in.
+func (q AnalyticsQuery) period(t time.Time) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch q.By {
+	case ByDay:
+		return day
+	case ByWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case ByMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return t
+}
+
+// accumulator sums, averages or takes the highest of the values of a series per period.
+type accumulator struct {
+	series  Series
+	sums    map[Point]float64 // by point without value; the highest value if highest
+	counts  map[Point]int
+	average bool
+	highest bool
+}
+
+func (a *accumulator) add(p Point, value float64) {
+	if a.highest {
+		if sum, ok := a.sums[p]; ok && sum >= value {
+			return
+		}
+		a.sums[p] = value
+		return
+	}
+	a.sums[p] += value
+	a.counts[p]++
+}
+
+func (a *accumulator) result() Series {
+	s := a.series
+	s.Points = []Point{}
+	for p, sum := range a.sums {
+		value := round2(sum)
+		if a.average {
+			value = round2(sum / float64(a.counts[p]))
+		}
+		p.Value = value
+		s.Points = append(s.Points, p)
+	}
+	sort.Slice(s.Points, func(i, j int) bool {
+		if s.Points[i].Time != s.Points[j].Time {
+			return s.Points[i].Time < s.Points[j].Time
+		}
+		return s.Points[i].Workout < s.Points[j].Workout
+	})
+	return s
+}
+
+// Analytics computes a metric over the user's sessions as time series.
+func Analytics(db Conn, userID uint64, q AnalyticsQuery) ([]Series, error) {
+	if err := q.valid(); err != nil {
+		return nil, err
+	}
+	var workouts []WorkoutDB
+	err := db.Collection("workouts").Find(up.Cond{
+		"user":         userID,
+		"startTime >":  0,
+		"startTime >=": q.From.Unix(),
+		"startTime <":  q.To.Unix(),
+	}).All(&workouts)
+	if err != nil {
+		return nil, err
+	}
+
+	series := map[string]*accumulator{}
+	seriesOf := func(name string, definition uint64) *accumulator {
+		if series[name] == nil {
+			series[name] = &accumulator{
+				series:  Series{Name: name, Definition: definition},
+				sums:    map[Point]float64{},
+				counts:  map[Point]int{},
+				average: q.Metric == MetricIntensity,
+				highest: q.Metric == MetricOneRepMax,
+			}
+		}
+		return series[name]
+	}
+	pointOf := func(w WorkoutDB) Point {
+		if q.By == ByWorkout {
+			return Point{Time: int64(w.StartTime), Workout: w.ID}
+		}
+		return Point{Time: q.period(time.Unix(int64(w.StartTime), 0)).Unix()}
+	}
+
+	switch q.Metric {
+	case MetricDuration:
+		for _, w := range workouts {
+			if w.HasDuration() {
+				seriesOf(PerTotal, 0).add(pointOf(w), float64(w.EndTime-w.StartTime))
+			}
+		}
+		return results(series), nil
+	case MetricSessions:
+		for _, w := range workouts {
+			seriesOf(PerTotal, 0).add(pointOf(w), 1)
+		}
+		return results(series), nil
+	}
+
+	byID := make(map[uint64]WorkoutDB, len(workouts))
+	workoutIDs := make([]uint64, len(workouts))
+	for i, w := range workouts {
+		byID[w.ID] = w
+		workoutIDs[i] = w.ID
+	}
+	if len(workoutIDs) == 0 {
+		return []Series{}, nil
+	}
+	var exercises []ExerciseDB
+	cond := up.Cond{"workout IN": workoutIDs}
+	if q.Definition != 0 {
+		cond["definition"] = q.Definition
+	}
+	if err := db.Collection("workout_exercises").Find(cond).All(&exercises); err != nil {
+		return nil, err
+	}
+	if len(exercises) == 0 {
+		return []Series{}, nil
+	}
+	exerciseOf := make(map[uint64]ExerciseDB, len(exercises))
+	exerciseIDs := make([]uint64, len(exercises))
+	definitionIDs := make([]uint64, len(exercises))
+	for i, e := range exercises {
+		exerciseOf[e.ID] = e
+		exerciseIDs[i] = e.ID
+		definitionIDs[i] = e.Definition
+	}
+	definitions, err := definitionsByID(db, definitionIDs)
+	if err != nil {
+		return nil, err
+	}
+	var sets []SetDB
+	if err := db.Collection("sets").Find(up.Cond{"exercise IN": exerciseIDs}).All(&sets); err != nil {
+		return nil, err
+	}
+	var oneRepMaxes map[uint64]float64
+	if q.Metric == MetricIntensity {
+		if oneRepMaxes, err = estimatedMaxes(db, userID, definitions); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range sets {
+		if s.Reps == 0 && s.Duration == 0 || !s.IsWork() { // not done, or a warm-up
+			continue
+		}
+		var value float64
+		switch q.Metric {
+		case MetricTonnage:
+			if s.Reps == 0 {
+				continue
+			}
+			value = float64(s.Reps) * s.Weight.ToUnit(q.Unit).Float()
+		case MetricSets:
+			value = 1
+		case MetricIntensity:
+			oneRepMax := oneRepMaxes[s.ID]
+			if s.Reps == 0 || s.Weight == 0 || oneRepMax == 0 {
+				continue
+			}
+			value = 100 * s.Weight.Float() / oneRepMax
+		case MetricOneRepMax:
+			if s.Reps == 0 || s.Weight == 0 {
+				continue
+			}
+			value = Epley(s.Weight.ToUnit(q.Unit), s.Reps)
+		}
+		exercise := exerciseOf[s.Exercise]
+		def := definitions[exercise.Definition]
+		p := pointOf(byID[exercise.Workout])
+		switch q.Per {
+		case PerTotal:
+			seriesOf(PerTotal, 0).add(p, value)
+		case PerExercise:
+			seriesOf(def.Name, def.ID).add(p, value)
+		case PerMuscle:
+			for _, muscle := range strings.Split(def.MuscleGroups, ",") {
+				if muscle = strings.TrimSpace(muscle); muscle != "" {
+					seriesOf(muscle, 0).add(p, value)
+				}
+			}
+		}
+	}
+	return results(series), nil
+}
+
+func results(series map[string]*accumulator) []Series {
+	all := make([]Series, 0, len(series))
+	for _, a := range series {
+		all = append(all, a.result())
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// estimatedMaxes returns, by set ID, the best one-rep max estimated with
+// Epley's formula from the work sets of the exercise the user had done up to
+// and including the set.
+func estimatedMaxes(db Conn, userID uint64, definitions map[uint64]ExerciseDefinitionDB) (map[uint64]float64, error) {
+	maxes := map[uint64]float64{}
+	for id := range definitions {
+		sets, err := definitionSets(db, userID, id)
+		if err != nil {
+			return nil, err
+		}
+		best := 0.0
+		for _, s := range sets {
+			if s.Reps > 0 && s.IsWork() {
+				if e := Epley(s.Weight, s.Reps); e > best {
+					best = e
+				}
+			}
+			maxes[s.ID] = best
+		}
+	}
+	return maxes, nil
+}
@@ -0,0 +1,246 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+const (
+	// SessionLifetime is how long a session lasts without being used.
+	SessionLifetime = 30 * 24 * time.Hour
+	// sessionRotateAfter is how long a session token is used before it is replaced.
+	sessionRotateAfter = 24 * time.Hour
+	// sessionRotateGrace is how long a replaced token keeps working, so
+	// requests already in flight with it, e.g. from other tabs, aren't logged out.
+	sessionRotateGrace = 2 * time.Minute
+	// sessionTouchAfter is how stale the recorded last use of a session gets
+	// before its expiry is pushed back, sparing a write on every request.
+	sessionTouchAfter = time.Hour
+	minPasswordLength = 8
+)
+
+var (
+	ErrBadLogin     = errors.New("bad user name and/or password")
+	ErrNoSession    = errors.New("no such session, or session expired")
+	ErrUserExists   = errors.New("user name already taken")
+	ErrBadUserName  = errors.New("user name must not be empty")
+	ErrWeakPassword = errors.New("password must be at least 8 characters")
+)
+
+// SessionDB is one logged-in device. Only a hash of the token is stored, so
+// a leaked database can't be used to hijack sessions.
+type SessionDB struct {
+	ID        uint64 `db:"id,omitempty"`
+	User      uint64 `db:"user"`
+	TokenHash string `db:"tokenHash"`
+	UserAgent string `db:"userAgent"`
+	CreatedAt int64  `db:"createdAt"` // unix time
+	RotatedAt int64  `db:"rotatedAt"` // unix time the current token was issued
+	ExpiresAt int64  `db:"expiresAt"` // unix time, SessionLifetime after the session was last used
+
+	// the token replaced by the last rotation, valid until PreviousExpiresAt
+	PreviousHash      string `db:"previousHash"`
+	PreviousExpiresAt int64  `db:"previousExpiresAt"` // unix time
+}
+
+// dummyHash is compared against when logging in as an unknown user, so that
+// takes as long as a wrong password and doesn't give away which names exist.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// HashPassword returns the salted bcrypt hash of password.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// isHashed distinguishes bcrypt hashes from passwords stored in plaintext before schema version 3.
+func isHashed(stored string) bool {
+	return strings.HasPrefix(stored, "$2")
+}
+
+// CreateUser adds a user with a hashed password.
+func CreateUser(db sqlbuilder.Database, name, password string) (UserDB, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return UserDB{}, ErrBadUserName
+	}
+	if len(password) < minPasswordLength {
+		return UserDB{}, ErrWeakPassword
+	}
+	hash, err := HashPassword(password)
+	if err != nil {
+		return UserDB{}, err
+	}
+	user := UserDB{Name: name, Password: hash, Role: RoleUser, Unit: UnitKg, PlateIncrement: DefaultPlateIncrements[UnitKg]}
+	err = db.Collection("users").InsertReturning(&user)
+	if isUniqueViolation(err) {
+		return UserDB{}, ErrUserExists
+	}
+	return user, err
+}
+
+// Authenticate returns the user with the given name and password. A
+// password still stored in plaintext is replaced with its hash on success.
+func Authenticate(db sqlbuilder.Database, name, password string) (UserDB, error) {
+	var user UserDB
+	err := db.Collection("users").Find(up.Cond{"name": name}).One(&user)
+	if err == up.ErrNoMoreRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return UserDB{}, ErrBadLogin
+	}
+	if err != nil {
+		return UserDB{}, err
+	}
+	if isHashed(user.Password) {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			return UserDB{}, ErrBadLogin
+		}
+		return user, nil
+	}
+	if user.Password == "" || subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		return UserDB{}, ErrBadLogin
+	}
+	if user.Password, err = HashPassword(password); err != nil {
+		return UserDB{}, err
+	}
+	if err = db.Collection("users").Find(user.ID).Update(user); err != nil {
+		return UserDB{}, err
+	}
+	return user, nil
+}
+
+func newToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSession starts a session for the user on a new device and returns its token.
+// Expired sessions of the user are cleaned up along the way.
+func NewSession(db sqlbuilder.Database, userID uint64, userAgent string) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	sessions := db.Collection("sessions")
+	err = sessions.Find(up.Cond{"user": userID, "expiresAt <": now.Unix()}).Delete()
+	if err != nil {
+		return "", err
+	}
+	_, err = sessions.Insert(SessionDB{
+		User:      userID,
+		TokenHash: hash,
+		UserAgent: userAgent,
+		CreatedAt: now.Unix(),
+		RotatedAt: now.Unix(),
+		ExpiresAt: now.Add(SessionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// SessionUser returns the user owning the session with the given token and
+// extends the session's expiry. When the token is due for rotation, a
+// replacement token is returned in rotated; the old token keeps working for
+// sessionRotateGrace.
+func SessionUser(db sqlbuilder.Database, token string) (user UserDB, rotated string, err error) {
+	var session SessionDB
+	now := time.Now()
+	hash := hashToken(token)
+	sessions := db.Collection("sessions")
+	err = sessions.Find(up.Or(
+		up.Cond{"tokenHash": hash},
+		up.Cond{"previousHash": hash, "previousExpiresAt >": now.Unix()},
+	)).One(&session)
+	if err == up.ErrNoMoreRows {
+		return UserDB{}, "", ErrNoSession
+	}
+	if err != nil {
+		return UserDB{}, "", err
+	}
+	if session.ExpiresAt <= now.Unix() {
+		sessions.Find(session.ID).Delete()
+		return UserDB{}, "", ErrNoSession
+	}
+	err = db.Collection("users").Find(session.User).One(&user)
+	if err == up.ErrNoMoreRows {
+		return UserDB{}, "", ErrNoSession
+	}
+	if err != nil {
+		return UserDB{}, "", err
+	}
+	if session.TokenHash != hash {
+		// a replaced token still in its grace period: the client has the new one
+		return user, "", nil
+	}
+	if now.Sub(time.Unix(session.RotatedAt, 0)) > sessionRotateAfter {
+		if rotated, err = rotateSession(db, session, now); err != nil {
+			return UserDB{}, "", err
+		}
+		return user, rotated, nil
+	}
+	expiresAt := now.Add(SessionLifetime).Unix()
+	if expiresAt-session.ExpiresAt > int64(sessionTouchAfter/time.Second) {
+		if err = sessions.Find(session.ID).Update(up.Cond{"expiresAt": expiresAt}); err != nil {
+			return UserDB{}, "", err
+		}
+	}
+	return user, "", nil
+}
+
+// rotateSession replaces the token of the session, keeping the old one valid
+// for sessionRotateGrace. The replacement only takes if the session still has
+// the token it was read with; when a concurrent request rotated it first, no
+// token is returned and the client carries on with the one from that request.
+func rotateSession(db sqlbuilder.Database, session SessionDB, now time.Time) (string, error) {
+	token, hash, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	res, err := db.Update("sessions").Set(
+		"tokenHash", hash,
+		"rotatedAt", now.Unix(),
+		"expiresAt", now.Add(SessionLifetime).Unix(),
+		"previousHash", session.TokenHash,
+		"previousExpiresAt", now.Add(sessionRotateGrace).Unix(),
+	).Where(up.Cond{"id": session.ID, "tokenHash": session.TokenHash}).Exec()
+	if err != nil {
+		return "", err
+	}
+	n, err := res.RowsAffected()
+	if err != nil || n == 0 {
+		return "", err
+	}
+	return token, nil
+}
+
+// RevokeSession ends the session with the given token.
+func RevokeSession(db sqlbuilder.Database, token string) error {
+	return db.Collection("sessions").Find(up.Cond{"tokenHash": hashToken(token)}).Delete()
+}
+
+// RevokeUserSessions ends every session of the user, logging out all their devices.
+func RevokeUserSessions(db sqlbuilder.Database, userID uint64) error {
+	return db.Collection("sessions").Find(up.Cond{"user": userID}).Delete()
+}
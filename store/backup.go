@@ -0,0 +1,277 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// BackupVersion is the version of the backup format written by WriteBackup.
+// Restore reads backups of this version and earlier. Version 2 added the
+// types of sets and the parents of drop sets.
+const BackupVersion = 2
+
+var ErrBackupVersion = errors.New("backup is of a newer format than this server reads")
+
+// Backup is everything of one user, as a JSON archive to restore on this or
+// another server. IDs are those of the server it was taken on; Restore gives
+// the records new ones. Login sessions aren't kept, and the catalog is only
+// there for the exercises the workouts use: it has no per-user favorites to keep.
+type Backup struct {
+	Version    int                    `json:"version"`   // BackupVersion when written
+	Schema     int                    `json:"schema"`    // of the database it was taken from
+	CreatedAt  int64                  `json:"createdAt"` // unix time in seconds
+	User       BackupUser             `json:"user"`
+	Workouts   []Workout              `json:"workouts"` // templates and sessions, weights in kilograms
+	RestTimers []RestTimerDB          `json:"restTimers"`
+	Catalog    []ExerciseDefinitionDB `json:"catalog"` // the exercises of the workouts, matched by name on restore
+}
+
+// BackupUser is the profile of the user of a backup.
+type BackupUser struct {
+	Name           string `json:"name"`
+	Password       string `json:"password"` // bcrypt hash, so the user logs in as before
+	Role           string `json:"role"`
+	Unit           string `json:"unit"`           // the weights of the backup are in kilograms regardless
+	PlateIncrement Weight `json:"plateIncrement"` // in Unit
+}
+
+// WriteBackup writes a Backup of the user. The workouts are read and written a
+// batch at a time, like an export.
+func WriteBackup(db sqlbuilder.Database, userID uint64, w io.Writer) error {
+	var user UserDB
+	err := db.Collection("users").Find(userID).One(&user)
+	if err == up.ErrNoMoreRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	schema, err := Version(db)
+	if err != nil {
+		return err
+	}
+	// the fields of a Backup before the arrays, which are written as they are read
+	head, err := json.Marshal(struct {
+		Version   int        `json:"version"`
+		Schema    int        `json:"schema"`
+		CreatedAt int64      `json:"createdAt"`
+		User      BackupUser `json:"user"`
+	}{BackupVersion, schema, time.Now().Unix(), BackupUser{user.Name, user.Password, user.Role, user.Unit, user.PlateIncrement}})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(head[:len(head)-1], `,"workouts":[`...)); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	first := true
+	var workoutIDs, definitionIDs []uint64
+	err = eachWorkout(db, userID, func(workout Workout) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		workoutIDs = append(workoutIDs, workout.ID)
+		for _, e := range workout.Exercises {
+			definitionIDs = append(definitionIDs, e.Definition)
+		}
+		return enc.Encode(workout)
+	})
+	if err != nil {
+		return err
+	}
+
+	timers := []RestTimerDB{}
+	if len(workoutIDs) > 0 {
+		if err := db.Collection("rest_timers").Find(up.Cond{"workout IN": workoutIDs}).All(&timers); err != nil {
+			return err
+		}
+	}
+	definitions, err := definitionsByID(db, definitionIDs)
+	if err != nil {
+		return err
+	}
+	catalog := []ExerciseDefinitionDB{}
+	for _, def := range definitions {
+		catalog = append(catalog, def)
+	}
+	sort.Slice(catalog, func(i, j int) bool { return catalog[i].ID < catalog[j].ID })
+	if _, err := io.WriteString(w, `],"restTimers":`); err != nil {
+		return err
+	}
+	if err := enc.Encode(timers); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, `,"catalog":`); err != nil {
+		return err
+	}
+	if err := enc.Encode(catalog); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "}\n")
+	return err
+}
+
+// RestoreOptions says how to restore a backup.
+type RestoreOptions struct {
+	Name     string // the user to restore as, if not the user of the backup
+	Replace  bool   // restore over an existing user of the name, replacing their workouts
+	KeepRole bool   // give the user the role of the backup, which may be admin
+}
+
+// Restore restores a backup as a user, and returns the user. The catalog
+// exercises of the backup are matched by name, and added to the catalog if
+// missing. Restoring over an existing user replaces their profile and
+// workouts with those of the backup and logs out every device of theirs. That
+// takes Replace, unless the user has the password of the backup, as does the
+// account it was taken from, so restoring the same backup again leaves the
+// same data, if under new IDs. It is all or nothing.
+//
+// A backup is a file its user can edit, so the role it holds is only taken
+// with KeepRole. Otherwise a new user is a plain user and an existing one
+// keeps their role.
+func Restore(db sqlbuilder.Database, backup Backup, opts RestoreOptions) (UserDB, error) {
+	if backup.Version > BackupVersion {
+		return UserDB{}, ErrBackupVersion
+	}
+	name := strings.TrimSpace(opts.Name)
+	if name == "" {
+		name = strings.TrimSpace(backup.User.Name)
+	}
+	if name == "" {
+		return UserDB{}, ErrBadUserName
+	}
+	user := UserDB{
+		Name:           name,
+		Password:       backup.User.Password,
+		Role:           RoleUser,
+		Unit:           backup.User.Unit,
+		PlateIncrement: backup.User.PlateIncrement,
+	}
+	if opts.KeepRole && backup.User.Role == RoleAdmin {
+		user.Role = RoleAdmin
+	}
+	if _, ok := DefaultPlateIncrements[user.Unit]; !ok {
+		user.Unit = UnitKg
+	}
+	if user.PlateIncrement <= 0 {
+		user.PlateIncrement = DefaultPlateIncrements[user.Unit]
+	}
+
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		users := tx.Collection("users")
+		var existing UserDB
+		err := users.Find(up.Cond{"name": name}).One(&existing)
+		switch {
+		case err == up.ErrNoMoreRows:
+			err := users.InsertReturning(&user)
+			if isUniqueViolation(err) {
+				return ErrUserExists
+			}
+			if err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !opts.Replace && existing.Password != user.Password:
+			return ErrUserExists
+		default:
+			user.ID = existing.ID
+			if !opts.KeepRole {
+				user.Role = existing.Role
+			}
+			if err := users.Find(user.ID).Update(user); err != nil {
+				return err
+			}
+			// the password may have changed with the rest
+			if err := tx.Collection("sessions").Find(up.Cond{"user": user.ID}).Delete(); err != nil {
+				return err
+			}
+			// exercises, sets and rest timers go with their workouts
+			if err := tx.Collection("workouts").Find(up.Cond{"user": user.ID}).Delete(); err != nil {
+				return err
+			}
+		}
+
+		definitions := map[uint64]uint64{} // backup ID -> ID here
+		catalog := tx.Collection("exercise_definitions")
+		for _, def := range backup.Catalog {
+			var here ExerciseDefinitionDB
+			err := catalog.Find(up.Cond{"name": def.Name}).One(&here)
+			if err == up.ErrNoMoreRows {
+				here = def
+				here.ID = 0
+				if here.DefaultType == "" { // backed up before exercises had a default set type
+					here.DefaultType = SetWorking
+				}
+				err = catalog.InsertReturning(&here)
+			}
+			if err != nil {
+				return err
+			}
+			definitions[def.ID] = here.ID
+		}
+
+		workouts := map[uint64]uint64{} // backup ID -> ID here, likewise below
+		sets := map[uint64]uint64{}
+		for _, w := range backup.Workouts {
+			workout := w.WorkoutDB
+			workout.ID, workout.User = 0, user.ID
+			if err := tx.Collection("workouts").InsertReturning(&workout); err != nil {
+				return err
+			}
+			workouts[w.ID] = workout.ID
+			for _, e := range w.Exercises {
+				exercise := e.ExerciseDB
+				exercise.ID, exercise.Workout = 0, workout.ID
+				if exercise.Definition = definitions[e.Definition]; exercise.Definition == 0 {
+					return ErrNotFound // not in the backup's catalog
+				}
+				if err := tx.Collection("workout_exercises").InsertReturning(&exercise); err != nil {
+					return err
+				}
+				for _, s := range e.Sets {
+					set := s
+					set.ID, set.Exercise, set.Parent, set.Records = 0, exercise.ID, 0, nil
+					if set.Type == "" { // version 1, typed like schema version 13 does
+						set.Type = SetWorking
+						if s.Reps == 0 && s.RepsExpected == 0 && (s.Duration > 0 || s.DurationExpected > 0) {
+							set.Type = SetTimed
+						}
+					}
+					if err := tx.Collection("sets").InsertReturning(&set); err != nil {
+						return err
+					}
+					sets[s.ID] = set.ID
+				}
+				if err := linkDropSets(tx, e.Sets, sets); err != nil {
+					return err
+				}
+			}
+		}
+		for _, t := range backup.RestTimers {
+			t.Workout, t.Set, t.Remaining = workouts[t.Workout], sets[t.Set], 0
+			if t.Workout == 0 || t.Set == 0 {
+				continue
+			}
+			if _, err := tx.Collection("rest_timers").Insert(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return UserDB{}, err
+	}
+	return user, nil
+}
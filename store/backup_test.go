@@ -0,0 +1,201 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// backupOf takes a backup of the user and reads it back.
+func backupOf(t *testing.T, db sqlbuilder.Database, userID uint64) Backup {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteBackup(db, userID, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var b Backup
+	if err := json.Unmarshal(buf.Bytes(), &b); err != nil {
+		t.Fatalf("reading the backup: %s\n%s", err, buf.Bytes())
+	}
+	return b
+}
+
+// withoutIDs returns the backup with its IDs replaced by the positions of the
+// records they refer to, and its time cleared, so backups of the same data
+// compare equal wherever they were taken.
+func withoutIDs(b Backup) Backup {
+	b.CreatedAt = 0
+	workouts, sets := map[uint64]uint64{}, map[uint64]uint64{}
+	var exercises uint64
+	out := make([]Workout, len(b.Workouts))
+	for i, w := range b.Workouts {
+		workouts[w.ID] = uint64(i + 1)
+		for _, e := range w.Exercises {
+			for _, s := range e.Sets {
+				sets[s.ID] = uint64(len(sets) + 1)
+			}
+		}
+		w.ID, w.User = workouts[w.ID], 0
+		w.Exercises = append([]Exercise(nil), w.Exercises...)
+		for j, e := range w.Exercises {
+			exercises++
+			e.ID, e.Workout, e.Definition = exercises, w.ID, 0 // the catalog exercise by Name
+			e.Sets = append([]SetDB(nil), e.Sets...)
+			for k, s := range e.Sets {
+				s.ID, s.Exercise, s.Parent = sets[s.ID], exercises, sets[s.Parent]
+				e.Sets[k] = s
+			}
+			w.Exercises[j] = e
+		}
+		out[i] = w
+	}
+	b.Workouts = out
+	b.RestTimers = append([]RestTimerDB(nil), b.RestTimers...)
+	for i, r := range b.RestTimers {
+		r.Workout, r.Set = workouts[r.Workout], sets[r.Set]
+		b.RestTimers[i] = r
+	}
+	b.Catalog = append([]ExerciseDefinitionDB(nil), b.Catalog...)
+	for i := range b.Catalog {
+		b.Catalog[i].ID = 0
+	}
+	return b
+}
+
+// backupFixture is a fixture with a drop set and a template as well, for a
+// backup with a bit of everything.
+func backupFixture(t *testing.T, db sqlbuilder.Database) fixture {
+	t.Helper()
+	f := newFixture(t, db, "alice")
+	drop := SetDB{Type: SetDrop, Parent: f.set.ID, RepsExpected: 8, WeightExpected: 40000}
+	if _, err := AddSet(db, f.user.ID, f.exercise.ID, drop); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreateTemplate(db, f.user.ID, "Push"); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// TestBackupRoundTrip restores a backup on another server and backs it up
+// again there: the two backups must hold the same data.
+func TestBackupRoundTrip(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	f := backupFixture(t, db)
+	before := backupOf(t, db, f.user.ID)
+	if len(before.Workouts) != 2 || len(before.RestTimers) != 1 || len(before.Catalog) != 1 {
+		t.Fatalf("backup of the fixture has %d workouts, %d rest timers and %d catalog exercises, want 2, 1 and 1",
+			len(before.Workouts), len(before.RestTimers), len(before.Catalog))
+	}
+
+	other, done := testDB(t)
+	defer done()
+	user, err := Restore(other, before, RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := backupOf(t, other, user.ID)
+	if got, want := withoutIDs(after), withoutIDs(before); !reflect.DeepEqual(got, want) {
+		t.Errorf("backup after a restore differs:\ngot  %+v\nwant %+v", got, want)
+	}
+}
+
+// TestRestoreAgain restores the same backup twice over the user it was taken
+// of: it needs no Replace, and leaves the same data as the first time.
+func TestRestoreAgain(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	f := backupFixture(t, db)
+	before := backupOf(t, db, f.user.ID)
+	for i := 0; i < 2; i++ {
+		user, err := Restore(db, before, RestoreOptions{})
+		if err != nil {
+			t.Fatalf("restore %d: %s", i+1, err)
+		}
+		if user.ID != f.user.ID {
+			t.Errorf("restore %d made user %d, want the user of the backup %d", i+1, user.ID, f.user.ID)
+		}
+		after := backupOf(t, db, user.ID)
+		if got, want := withoutIDs(after), withoutIDs(before); !reflect.DeepEqual(got, want) {
+			t.Errorf("backup after restore %d differs:\ngot  %+v\nwant %+v", i+1, got, want)
+		}
+	}
+	// restoring over the user logged them out
+	if n := count(t, db, "sessions", up.Cond{"user": f.user.ID}); n != 0 {
+		t.Errorf("%d sessions of the restored user left, want 0", n)
+	}
+	if n := count(t, db, "exercise_definitions", up.Cond{"name": "Squat"}); n != 1 {
+		t.Errorf("%d catalog exercises named Squat, want 1", n)
+	}
+}
+
+func TestRestoreOverAnotherUser(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	f := backupFixture(t, db)
+	backup := backupOf(t, db, f.user.ID)
+	bob, err := CreateUser(db, "bob", "password2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bob has another password, so restoring as him takes Replace
+	if _, err := Restore(db, backup, RestoreOptions{Name: "bob"}); err != ErrUserExists {
+		t.Fatalf("restore as bob: %v, want %v", err, ErrUserExists)
+	}
+	if n := count(t, db, "workouts", up.Cond{"user": bob.ID}); n != 0 {
+		t.Errorf("bob has %d workouts after a refused restore, want 0", n)
+	}
+	user, err := Restore(db, backup, RestoreOptions{Name: "bob", Replace: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != bob.ID || user.Password != backup.User.Password {
+		t.Errorf("restore as bob made user %d of password %q, want user %d of the password of the backup", user.ID, user.Password, bob.ID)
+	}
+	if n := count(t, db, "workouts", up.Cond{"user": bob.ID}); n != 2 {
+		t.Errorf("bob has %d workouts after the restore, want 2", n)
+	}
+	// alice keeps hers
+	if n := count(t, db, "workouts", up.Cond{"user": f.user.ID}); n != 2 {
+		t.Errorf("alice has %d workouts, want 2", n)
+	}
+}
+
+// A backup is a file its user can edit, so the role it holds is only taken
+// with KeepRole.
+func TestRestoreRole(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	f := backupFixture(t, db)
+	backup := backupOf(t, db, f.user.ID)
+	backup.User.Role = RoleAdmin
+
+	for _, c := range []struct {
+		name string
+		opts RestoreOptions
+		want string
+	}{
+		{"carol", RestoreOptions{Name: "carol"}, RoleUser},
+		{"alice", RestoreOptions{}, RoleUser}, // over an existing user, who keeps their role
+		{"dave", RestoreOptions{Name: "dave", KeepRole: true}, RoleAdmin},
+		{"alice", RestoreOptions{KeepRole: true}, RoleAdmin},
+	} {
+		user, err := Restore(db, backup, c.opts)
+		if err != nil {
+			t.Fatalf("restore as %s: %s", c.name, err)
+		}
+		var stored UserDB
+		if err := db.Collection("users").Find(user.ID).One(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if user.Role != c.want || stored.Role != c.want {
+			t.Errorf("restore as %s with %+v: role %q, stored %q, want %q", c.name, c.opts, user.Role, stored.Role, c.want)
+		}
+	}
+}
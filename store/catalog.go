@@ -0,0 +1,220 @@
+package store
+
+import (
+	"errors"
+	"strings"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+var (
+	ErrExerciseExists  = errors.New("an exercise of that name is already in the catalog")
+	ErrBadExerciseName = errors.New("exercise name must not be empty")
+	ErrExerciseInUse   = errors.New("exercise is part of some workout, so it can't be removed from the catalog")
+	ErrBadDefaults     = errors.New("default sets, reps, duration and rest must not be negative")
+	ErrBadProgression  = errors.New("unknown progression rule, or increment, rep range or deload out of range")
+	ErrBadAlias        = errors.New("alias must not be empty")
+)
+
+// ExerciseAliasDB is another name of a catalog exercise, such as the name
+// another app gives it, which imports match as the exercise.
+type ExerciseAliasDB struct {
+	Alias      string `db:"alias" json:"alias"` // normalized, see normalizeName
+	Definition uint64 `db:"definition" json:"definition"`
+}
+
+// ProgressionPatch is a partial update of the progression settings of a
+// catalog exercise.
+type ProgressionPatch struct {
+	Progression   *string `json:"progression,omitempty"`
+	Increment     *Weight `json:"increment,omitempty"`
+	RepsMax       *int    `json:"repsMax,omitempty"`
+	DeloadAfter   *int    `json:"deloadAfter,omitempty"`
+	DeloadPercent *int    `json:"deloadPercent,omitempty"`
+}
+
+func validProgression(def ExerciseDefinitionDB) error {
+	if _, ok := progressions[def.Progression]; def.Progression != "" && !ok {
+		return ErrBadProgression
+	}
+	if def.Increment < 0 || def.RepsMax < 0 || def.DeloadAfter < 0 || def.DeloadPercent < 0 || def.DeloadPercent > 100 {
+		return ErrBadProgression
+	}
+	return nil
+}
+
+// Catalog returns every entry of the exercise catalog by name.
+func Catalog(db Conn) ([]ExerciseDefinitionDB, error) {
+	definitions := []ExerciseDefinitionDB{}
+	err := db.Collection("exercise_definitions").Find().OrderBy("name").All(&definitions)
+	return definitions, err
+}
+
+// Definition returns an entry of the exercise catalog.
+func Definition(db Conn, definitionID uint64) (ExerciseDefinitionDB, error) {
+	var def ExerciseDefinitionDB
+	err := db.Collection("exercise_definitions").Find(definitionID).One(&def)
+	if err == up.ErrNoMoreRows {
+		return ExerciseDefinitionDB{}, ErrNotFound
+	}
+	return def, err
+}
+
+// normalizeName reduces an exercise name to the form names are matched in:
+// lower case, with hyphens and underscores as spaces and runs of spaces as one.
+func normalizeName(name string) string {
+	name = strings.NewReplacer("-", " ", "_", " ").Replace(strings.ToLower(name))
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// AddAlias makes a name match a catalog exercise, in place of any exercise
+// it matched before.
+func AddAlias(db Conn, alias string, definitionID uint64) (ExerciseAliasDB, error) {
+	a := ExerciseAliasDB{Alias: normalizeName(alias), Definition: definitionID}
+	if a.Alias == "" {
+		return ExerciseAliasDB{}, ErrBadAlias
+	}
+	if _, err := Definition(db, definitionID); err != nil {
+		return ExerciseAliasDB{}, err
+	}
+	aliases := db.Collection("exercise_aliases")
+	if err := aliases.Find(up.Cond{"alias": a.Alias}).Delete(); err != nil {
+		return ExerciseAliasDB{}, err
+	}
+	_, err := aliases.Insert(a)
+	return a, err
+}
+
+// AddDefinition adds an exercise to the catalog. Names are unique.
+func AddDefinition(db Conn, def ExerciseDefinitionDB) (ExerciseDefinitionDB, error) {
+	def.ID = 0
+	def.Name = strings.TrimSpace(def.Name)
+	if def.Name == "" {
+		return ExerciseDefinitionDB{}, ErrBadExerciseName
+	}
+	if def.DefaultSets < 0 || def.DefaultReps < 0 || def.DefaultRest < 0 || def.DefaultDuration < 0 {
+		return ExerciseDefinitionDB{}, ErrBadDefaults
+	}
+	if def.DefaultType == "" {
+		def.DefaultType = SetWorking
+	}
+	if err := validSetType(db, SetDB{Type: def.DefaultType, RepsExpected: def.DefaultReps}); err != nil {
+		return ExerciseDefinitionDB{}, err
+	}
+	if err := validProgression(def); err != nil {
+		return ExerciseDefinitionDB{}, err
+	}
+	catalog := db.Collection("exercise_definitions")
+	exists, err := catalog.Find(up.Cond{"name": def.Name}).Exists()
+	if err != nil {
+		return ExerciseDefinitionDB{}, err
+	}
+	if exists {
+		return ExerciseDefinitionDB{}, ErrExerciseExists
+	}
+	err = catalog.InsertReturning(&def)
+	return def, err
+}
+
+// UpdateProgression changes how sessions of a catalog exercise progress.
+// Sessions already planned are left as they are.
+func UpdateProgression(db Conn, definitionID uint64, patch ProgressionPatch) (ExerciseDefinitionDB, error) {
+	var def ExerciseDefinitionDB
+	err := db.Collection("exercise_definitions").Find(definitionID).One(&def)
+	if err == up.ErrNoMoreRows {
+		return ExerciseDefinitionDB{}, ErrNotFound
+	}
+	if err != nil {
+		return ExerciseDefinitionDB{}, err
+	}
+	if patch.Progression != nil {
+		def.Progression = *patch.Progression
+	}
+	if patch.Increment != nil {
+		def.Increment = *patch.Increment
+	}
+	for _, f := range []struct {
+		patch *int
+		field *int
+	}{
+		{patch.RepsMax, &def.RepsMax},
+		{patch.DeloadAfter, &def.DeloadAfter},
+		{patch.DeloadPercent, &def.DeloadPercent},
+	} {
+		if f.patch != nil {
+			*f.field = *f.patch
+		}
+	}
+	if err := validProgression(def); err != nil {
+		return ExerciseDefinitionDB{}, err
+	}
+	err = db.Collection("exercise_definitions").Find(definitionID).Update(def)
+	return def, err
+}
+
+// RemoveDefinition removes an exercise from the catalog. Exercises still
+// used by a workout or template can't be removed.
+func RemoveDefinition(db Conn, definitionID uint64) error {
+	catalog := db.Collection("exercise_definitions")
+	exists, err := catalog.Find(definitionID).Exists()
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	inUse, err := db.Collection("workout_exercises").Find(up.Cond{"definition": definitionID}).Exists()
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrExerciseInUse
+	}
+	return catalog.Find(definitionID).Delete()
+}
+
+// AddExercise adds an exercise from the catalog to one of the user's
+// workouts. It starts with the catalog's default number of sets, each of the
+// default type and expecting the default reps, duration and rest. Weights are
+// left for the user to fill in.
+func AddExercise(db sqlbuilder.Database, userID, workoutID, definitionID uint64, notes string) (Exercise, error) {
+	var exercise Exercise
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		if _, err := editableWorkout(tx, userID, workoutID); err != nil {
+			return err
+		}
+		var def ExerciseDefinitionDB
+		err := tx.Collection("exercise_definitions").Find(definitionID).One(&def)
+		if err == up.ErrNoMoreRows {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		exercise = Exercise{
+			ExerciseDB: ExerciseDB{Workout: workoutID, Definition: def.ID, Notes: notes},
+			Name:       def.Name,
+			Sets:       []SetDB{},
+		}
+		if err := tx.Collection("workout_exercises").InsertReturning(&exercise.ExerciseDB); err != nil {
+			return err
+		}
+		for i := 0; i < def.DefaultSets; i++ {
+			set := SetDB{
+				Order:            i,
+				Type:             def.DefaultType,
+				RepsExpected:     def.DefaultReps,
+				DurationExpected: def.DefaultDuration,
+				RestExpected:     def.DefaultRest,
+				Exercise:         exercise.ID,
+			}
+			if err := tx.Collection("sets").InsertReturning(&set); err != nil {
+				return err
+			}
+			exercise.Sets = append(exercise.Sets, set)
+		}
+		return nil
+	})
+	return exercise, err
+}
@@ -0,0 +1,105 @@
+package store
+
+import (
+	"time"
+
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// CopyWorkout starts a new session for the user that repeats one of their
+// workouts or templates: every exercise and set is cloned, and what was
+// actually done in each set becomes what is expected of the copy. Exercises
+// the catalog gives a progression rule are planned by the rule instead, from
+// how their last session went.
+func CopyWorkout(db sqlbuilder.Database, userID, workoutID uint64) (WorkoutDB, error) {
+	return copyWorkout(db, userID, workoutID, uint64(time.Now().Unix()))
+}
+
+// copyWorkout clones the workout as a session started at startTime, or as a
+// template if startTime is 0.
+func copyWorkout(db sqlbuilder.Database, userID, workoutID uint64, startTime uint64) (WorkoutDB, error) {
+	var copied WorkoutDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		workout, err := LoadWorkout(tx, userID, workoutID)
+		if err != nil {
+			return err
+		}
+		copied = workout.WorkoutDB
+		copied.ID = 0 // must be zero for auto-increment ID
+		copied.StartTime = startTime
+		copied.EndTime = 0
+		if err := tx.Collection("workouts").InsertReturning(&copied); err != nil {
+			return err
+		}
+		definitionIDs := make([]uint64, len(workout.Exercises))
+		for i, e := range workout.Exercises {
+			definitionIDs[i] = e.Definition
+		}
+		definitions, err := definitionsByID(tx, definitionIDs)
+		if err != nil {
+			return err
+		}
+		for _, e := range workout.Exercises {
+			exercise := e.ExerciseDB
+			exercise.ID = 0
+			exercise.Workout = copied.ID
+			if err := tx.Collection("workout_exercises").InsertReturning(&exercise); err != nil {
+				return err
+			}
+			planned := make([]SetDB, len(e.Sets))
+			for i, s := range e.Sets {
+				planned[i] = plannedFrom(s)
+			}
+			if !copied.IsTemplate() {
+				if planned, err = progress(tx, userID, definitions[e.Definition], planned); err != nil {
+					return err
+				}
+			}
+			copies := map[uint64]uint64{} // set ID -> ID of its copy
+			for i, set := range planned {
+				set.Exercise = exercise.ID
+				if err := tx.Collection("sets").InsertReturning(&set); err != nil {
+					return err
+				}
+				copies[e.Sets[i].ID] = set.ID
+			}
+			if err := linkDropSets(tx, e.Sets, copies); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return copied, err
+}
+
+// plannedFrom returns a fresh set of the type of s whose expected values are
+// the actual values of s, the duration only for a timed set. A set that was
+// never performed keeps its expected values instead. Drop sets are left
+// without a parent, as the copy of their parent has yet to be saved.
+func plannedFrom(s SetDB) SetDB {
+	planned := SetDB{
+		Order:            s.Order,
+		Type:             s.Type,
+		RepsExpected:     s.RepsExpected,
+		WeightExpected:   s.WeightExpected,
+		DurationExpected: s.DurationExpected,
+		RestExpected:     s.RestExpected,
+	}
+	// every set done in a live session has a duration, but only for timed
+	// sets is it the target
+	timed := s.Type == SetTimed
+	if s.Reps != 0 || s.Weight != 0 || timed && s.Duration != 0 {
+		planned.RepsExpected = s.Reps
+		planned.WeightExpected = s.Weight
+		if timed {
+			planned.DurationExpected = s.Duration
+		}
+	}
+	if !s.HasRepTarget() {
+		planned.RepsExpected = 0
+	}
+	if s.Rest != 0 {
+		planned.RestExpected = s.Rest
+	}
+	return planned
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"upper.io/db.v3/lib/sqlbuilder"
+	"upper.io/db.v3/postgresql"
+	"upper.io/db.v3/sqlite"
+)
+
+// SQLiteFilePath is the database file used in dev mode.
+const SQLiteFilePath = "userData.dat"
+
+// OpenSQLite opens (creating if need be) the SQLite database file at path.
+// Foreign keys are enforced, as SQLite otherwise ignores them and deletes wouldn't cascade.
+func OpenSQLite(path string) (sqlbuilder.Database, error) {
+	return sqlite.Open(sqlite.ConnectionURL{
+		Database: path,
+		Options:  map[string]string{"_foreign_keys": "1"},
+	})
+}
+
+// OpenPostgres opens the Postgres database at url, e.g. the DATABASE_URL Heroku provides.
+func OpenPostgres(url string) (sqlbuilder.Database, error) {
+	connURL, err := postgresql.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	return postgresql.Open(connURL)
+}
+
+// isUniqueViolation reports whether err is a write refused by a UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	switch err := err.(type) {
+	case sqlite3.Error:
+		return err.ExtendedCode == sqlite3.ErrConstraintUnique
+	case *pq.Error:
+		return err.Code == "23505" // unique_violation
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// testDB opens a fresh SQLite database at the latest schema version, with
+// foreign keys enforced as in dev mode. Call done when finished with it.
+func testDB(t *testing.T) (db sqlbuilder.Database, done func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "workouttracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenSQLite(filepath.Join(dir, SQLiteFilePath))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	done = func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+	if err := Migrate(db); err != nil {
+		done()
+		t.Fatal(err)
+	}
+	return db, done
+}
+
+// fixture is a user with a session in progress: one exercise of two sets,
+// the first of them completed, which started the rest timer.
+type fixture struct {
+	user     UserDB
+	workout  WorkoutDB
+	exercise Exercise
+	set      SetDB // the completed set
+}
+
+func newFixture(t *testing.T, db sqlbuilder.Database, name string) fixture {
+	t.Helper()
+	if _, err := SeedExercises(db); err != nil {
+		t.Fatal(err)
+	}
+	var squat ExerciseDefinitionDB
+	if err := db.Collection("exercise_definitions").Find(up.Cond{"name": "Squat"}).One(&squat); err != nil {
+		t.Fatal(err)
+	}
+	var f fixture
+	var err error
+	if f.user, err = CreateUser(db, name, "password1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewSession(db, f.user.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+	f.workout, err = AddWorkout(db, f.user.ID, WorkoutDB{Name: "Monday", StartTime: uint64(time.Now().Unix())})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.exercise, err = AddExercise(db, f.user.ID, f.workout.ID, squat.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.exercise.Sets) < 2 {
+		t.Fatalf("exercise has %d sets, want at least 2", len(f.exercise.Sets))
+	}
+	if _, err = StartSet(db, f.user.ID, f.exercise.Sets[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if f.set, err = CompleteSet(db, f.user.ID, f.exercise.Sets[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// count returns the number of rows of the table matching cond.
+func count(t *testing.T, db Conn, table string, cond up.Cond) uint64 {
+	t.Helper()
+	n, err := db.Collection(table).Find(cond).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"testing"
+
+	up "upper.io/db.v3"
+)
+
+func TestDeleteUserCascades(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	f := newFixture(t, db, "alice")
+	other := newFixture(t, db, "bob")
+
+	if err := db.Collection("users").Find(f.user.ID).Delete(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		table string
+		cond  up.Cond
+	}{
+		{"users", up.Cond{"id": f.user.ID}},
+		{"sessions", up.Cond{"user": f.user.ID}},
+		{"workouts", up.Cond{"user": f.user.ID}},
+		{"workout_exercises", up.Cond{"workout": f.workout.ID}},
+		{"sets", up.Cond{"exercise": f.exercise.ID}},
+		{"rest_timers", up.Cond{"workout": f.workout.ID}},
+	} {
+		if n := count(t, db, c.table, c.cond); n != 0 {
+			t.Errorf("%d rows of %s left of the removed user", n, c.table)
+		}
+	}
+	// nothing of the other user goes with it
+	for _, c := range []struct {
+		table string
+		cond  up.Cond
+		want  uint64
+	}{
+		{"users", up.Cond{"id": other.user.ID}, 1},
+		{"sessions", up.Cond{"user": other.user.ID}, 1},
+		{"workouts", up.Cond{"user": other.user.ID}, 1},
+		{"workout_exercises", up.Cond{"workout": other.workout.ID}, 1},
+		{"sets", up.Cond{"exercise": other.exercise.ID}, uint64(len(other.exercise.Sets))},
+		{"rest_timers", up.Cond{"workout": other.workout.ID}, 1},
+	} {
+		if n := count(t, db, c.table, c.cond); n != c.want {
+			t.Errorf("%d rows of %s of the other user, want %d", n, c.table, c.want)
+		}
+	}
+}
+
+func TestDeleteWorkoutCascades(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	f := newFixture(t, db, "alice")
+
+	if err := db.Collection("workouts").Find(f.workout.ID).Delete(); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		table string
+		cond  up.Cond
+	}{
+		{"workouts", up.Cond{"id": f.workout.ID}},
+		{"workout_exercises", up.Cond{"workout": f.workout.ID}},
+		{"sets", up.Cond{"exercise": f.exercise.ID}},
+		{"rest_timers", up.Cond{"workout": f.workout.ID}},
+	} {
+		if n := count(t, db, c.table, c.cond); n != 0 {
+			t.Errorf("%d rows of %s left of the removed workout", n, c.table)
+		}
+	}
+	// the user and their sessions stay
+	if n := count(t, db, "users", up.Cond{"id": f.user.ID}); n != 1 {
+		t.Errorf("%d users left, want 1", n)
+	}
+	if n := count(t, db, "sessions", up.Cond{"user": f.user.ID}); n != 1 {
+		t.Errorf("%d sessions of the user left, want 1", n)
+	}
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	up "upper.io/db.v3"
+)
+
+// exportBatch is how many workouts an export reads at a time, so a long
+// history is never held in memory all at once.
+const exportBatch = 50
+
+// ExportColumns are the columns of a CSV export. Times are RFC 3339 in UTC,
+// empty when not set; durations and rests are in milliseconds, and weights
+// in the user's unit, the one of weight_unit. The parent of a drop set is
+// the set column of another row, empty for other sets.
+var ExportColumns = []string{
+	"workout", "workout_name", "template", "start_time", "end_time",
+	"exercise", "exercise_name", "exercise_notes",
+	"set", "set_order", "set_type", "set_parent", "reps", "reps_expected", "weight", "weight_expected", "weight_unit",
+	"duration", "duration_expected", "rest", "rest_expected", "set_started_at", "set_completed_at",
+}
+
+// eachWorkout calls fn with every workout of the user, templates included,
+// fully loaded, in the order they were created.
+func eachWorkout(db Conn, userID uint64, fn func(Workout) error) error {
+	var last uint64
+	for {
+		var batch []WorkoutDB
+		err := db.Collection("workouts").Find(up.Cond{"user": userID, "id >": last}).
+			OrderBy("id").Limit(exportBatch).All(&batch)
+		if err != nil || len(batch) == 0 {
+			return err
+		}
+		workouts, err := assembleWorkouts(db, batch)
+		if err != nil {
+			return err
+		}
+		for _, w := range workouts {
+			if err := fn(w); err != nil {
+				return err
+			}
+		}
+		last = batch[len(batch)-1].ID
+	}
+}
+
+// ExportJSON writes every workout of the user as a JSON array of Workout,
+// with weights in the user's unit. Records are left out, as they are worked
+// out from the sets.
+func ExportJSON(db Conn, userID uint64, w io.Writer) error {
+	unit, err := unitOfUser(db, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	first := true
+	err = eachWorkout(db, userID, func(workout Workout) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		return enc.Encode(workout.ToUnit(unit))
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]\n")
+	return err
+}
+
+// ExportCSV writes every workout of the user as CSV with ExportColumns, one
+// row per set. Exercises without sets, and workouts without exercises, get a
+// row of their own with the columns they don't have left empty.
+func ExportCSV(db Conn, userID uint64, w io.Writer) error {
+	unit, err := unitOfUser(db, userID)
+	if err != nil {
+		return err
+	}
+	out := csv.NewWriter(w)
+	if err := out.Write(ExportColumns); err != nil {
+		return err
+	}
+	err = eachWorkout(db, userID, func(workout Workout) error {
+		row := []string{
+			formatID(workout.ID), workout.Name, strconv.FormatBool(workout.IsTemplate()),
+			unixTime(int64(workout.StartTime), time.Second), unixTime(int64(workout.EndTime), time.Second),
+		}
+		if len(workout.Exercises) == 0 {
+			return out.Write(pad(row))
+		}
+		for _, e := range workout.Exercises {
+			row := append(row[:5:5], formatID(e.ID), e.Name, e.Notes)
+			if len(e.Sets) == 0 {
+				if err := out.Write(pad(row)); err != nil {
+					return err
+				}
+			}
+			for _, s := range e.Sets {
+				s = s.ToUnit(unit)
+				err := out.Write(append(row[:8:8],
+					formatID(s.ID), strconv.Itoa(s.Order), s.Type, parentID(s.Parent),
+					strconv.Itoa(s.Reps), strconv.Itoa(s.RepsExpected),
+					s.Weight.String(), s.WeightExpected.String(), unit,
+					strconv.Itoa(s.Duration), strconv.Itoa(s.DurationExpected),
+					strconv.Itoa(s.Rest), strconv.Itoa(s.RestExpected),
+					unixTime(s.StartedAt, time.Millisecond), unixTime(s.CompletedAt, time.Millisecond),
+				))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		// flush per workout so the export streams rather than piling up
+		out.Flush()
+		return out.Error()
+	})
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func formatID(n uint64) string {
+	return strconv.FormatUint(n, 10)
+}
+
+// parentID formats the parent of a set, empty for none.
+func parentID(n uint64) string {
+	if n == 0 {
+		return ""
+	}
+	return formatID(n)
+}
+
+// unixTime formats a unix time in the given unit, empty for 0.
+func unixTime(t int64, unit time.Duration) string {
+	if t == 0 {
+		return ""
+	}
+	return time.Unix(0, t*int64(unit)).UTC().Format(time.RFC3339)
+}
+
+// pad fills a CSV row out to every column.
+func pad(row []string) []string {
+	return append(row, make([]string, len(ExportColumns)-len(row))...)
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"sort"
+
+	up "upper.io/db.v3"
+)
+
+// ExerciseSession is a catalog exercise as done in one of the user's sessions.
+// A session holding the exercise twice has an ExerciseSession for each.
+type ExerciseSession struct {
+	Workout   uint64  `json:"workout"`
+	Name      string  `json:"name"`      // of the session
+	StartTime uint64  `json:"startTime"` // unix time in seconds
+	EndTime   uint64  `json:"endTime"`   // unix time in seconds, 0 while in progress
+	Exercise  uint64  `json:"exercise"`
+	Sets      []SetDB `json:"sets"` // by Order, with the records they hold
+}
+
+// HistorySet is a set of a catalog exercise along with when it was done.
+type HistorySet struct {
+	SetDB
+	Workout uint64 `json:"workout"`
+	At      int64  `json:"at"` // unix time in milliseconds of its completion, or else of the start of its session
+}
+
+// ExerciseHistory is everything the user has logged of a catalog exercise.
+type ExerciseHistory struct {
+	Definition ExerciseDefinitionDB `json:"definition"`
+	Sessions   []ExerciseSession    `json:"sessions"` // latest first
+	Best       *HistorySet          `json:"best"`     // highest estimated one-rep max, or longest set of an exercise done for time, of the work sets; nil until one is done
+	Latest     *HistorySet          `json:"latest"`   // the last set done; nil until a set is done
+}
+
+// History returns every session in which the user did a catalog exercise.
+func History(db Conn, userID, definitionID uint64) (ExerciseHistory, error) {
+	def, err := Definition(db, definitionID)
+	if err != nil {
+		return ExerciseHistory{}, err
+	}
+	history := ExerciseHistory{Definition: def, Sessions: []ExerciseSession{}}
+	sets, err := definitionSets(db, userID, definitionID)
+	if err != nil || len(sets) == 0 {
+		return history, err
+	}
+	kinds := recordKinds(records(definitionID, sets))
+
+	index := map[uint64]int{} // exercise ID -> position in history.Sessions
+	var workoutIDs []uint64
+	bestEstimate, bestDuration := 0.0, 0
+	for _, s := range sets {
+		s.Records = kinds[s.ID]
+		if _, ok := index[s.Exercise]; !ok {
+			index[s.Exercise] = len(history.Sessions)
+			history.Sessions = append(history.Sessions, ExerciseSession{
+				Workout:   s.Workout,
+				StartTime: s.StartTime,
+				Exercise:  s.Exercise,
+			})
+			workoutIDs = append(workoutIDs, s.Workout)
+		}
+		session := &history.Sessions[index[s.Exercise]]
+		session.Sets = append(session.Sets, s.SetDB)
+
+		done := &HistorySet{SetDB: s.SetDB, Workout: s.Workout, At: s.At}
+		switch {
+		case !s.IsWork():
+			if s.Reps > 0 || s.Duration > 0 {
+				history.Latest = done
+			}
+		case s.Reps > 0:
+			history.Latest = done
+			if e := Epley(s.Weight, s.Reps); e > bestEstimate {
+				history.Best, bestEstimate = done, e
+			}
+		case s.Duration > 0:
+			history.Latest = done
+			if bestEstimate == 0 && s.Duration > bestDuration {
+				history.Best, bestDuration = done, s.Duration
+			}
+		}
+	}
+
+	var workouts []WorkoutDB
+	if err := db.Collection("workouts").Find(up.Cond{"id IN": workoutIDs}).All(&workouts); err != nil {
+		return ExerciseHistory{}, err
+	}
+	byID := make(map[uint64]WorkoutDB, len(workouts))
+	for _, w := range workouts {
+		byID[w.ID] = w
+	}
+	for i := range history.Sessions {
+		session := &history.Sessions[i]
+		session.Name = byID[session.Workout].Name
+		session.EndTime = byID[session.Workout].EndTime
+		sort.Slice(session.Sets, func(i, j int) bool { return session.Sets[i].Order < session.Sets[j].Order })
+	}
+	sort.SliceStable(history.Sessions, func(i, j int) bool {
+		a, b := history.Sessions[i], history.Sessions[j]
+		if a.StartTime != b.StartTime {
+			return a.StartTime > b.StartTime
+		}
+		return a.Exercise > b.Exercise
+	})
+	return history, nil
+}
@@ -0,0 +1,532 @@
+package store
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// Formats of the CSV exports of other workout apps that Import reads.
+const (
+	ImportStrong   = "strong"
+	ImportHevy     = "hevy"
+	ImportFitNotes = "fitnotes"
+)
+
+var ErrUnmatchedExercises = errors.New("some exercises match no catalog exercise or alias")
+
+// ImportError is an export Import can't read.
+type ImportError struct {
+	Line int // the record of the CSV file, counting the header as 1; 0 when about the file as a whole
+	Msg  string
+}
+
+func (e *ImportError) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ImportOptions says how to read an export.
+type ImportOptions struct {
+	Format     string            // one of the Import formats, or empty to tell from the header
+	SourceUnit string            // the unit of an export that doesn't say, as Strong's usually don't; the user's if empty
+	Location   *time.Location    // the time zone of the export's times, which have none; UTC if nil
+	Exercises  map[string]uint64 // catalog exercises for the names of the export that match none by name or alias
+	DryRun     bool              // read and match, but save nothing
+}
+
+// ImportResult is what an import saved, or would save on a dry run.
+type ImportResult struct {
+	Format     string    `json:"format"`
+	DryRun     bool      `json:"dryRun"`
+	Workouts   []Workout `json:"workouts"`   // the sessions imported, without IDs on a dry run
+	Sets       int       `json:"sets"`       // in Workouts
+	Duplicates []string  `json:"duplicates"` // sessions left out as already logged, with the same name and start time
+	Unmatched  []string  `json:"unmatched"`  // exercise names of the export matching no catalog exercise
+}
+
+// importRow is a set of an export along with the session and exercise it is part of.
+type importRow struct {
+	line     int
+	session  string
+	start    time.Time
+	end      time.Time // zero if the export doesn't say
+	exercise string
+	notes    string  // of the exercise
+	weight   float64 // in unit
+	unit     string
+	set      SetDB // all but the weight
+	restOnly bool  // a row holding only the rest after the previous set
+}
+
+// csvRow is a record of a CSV file with a header.
+type csvRow struct {
+	line    int
+	columns map[string]int // by lower case name
+	fields  []string
+}
+
+func (r csvRow) has(column string) bool {
+	_, ok := r.columns[column]
+	return ok
+}
+
+func (r csvRow) get(column string) string {
+	i, ok := r.columns[column]
+	if !ok || i >= len(r.fields) {
+		return ""
+	}
+	return strings.TrimSpace(r.fields[i])
+}
+
+// number reads a number, 0 if empty. Decimal commas are taken as points.
+func (r csvRow) number(column string) (float64, error) {
+	s := strings.Replace(r.get(column), ",", ".", 1)
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, &ImportError{r.line, fmt.Sprintf("%s is not a number: %q", column, r.get(column))}
+	}
+	return n, nil
+}
+
+// timeLayouts are the layouts of the times of the supported exports.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05", // Strong
+	"2006-01-02 15:04",
+	"2 Jan 2006, 15:04", // Hevy
+	time.RFC3339,
+	"2006-01-02", // FitNotes
+}
+
+// fitNotesWeights are the weight columns of FitNotes exports, which name
+// their unit. The first one a file has is read.
+var fitNotesWeights = []struct{ column, unit string }{
+	{"weight (kgs)", UnitKg},
+	{"weight (kg)", UnitKg},
+	{"weight (lbs)", UnitLb},
+	{"weight (lb)", UnitLb},
+}
+
+func (r csvRow) time(column string, loc *time.Location) (time.Time, error) {
+	s := r.get(column)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, &ImportError{r.line, fmt.Sprintf("%s is not a time: %q", column, s)}
+}
+
+// seconds reads Strong's "1h 5m"-style durations, or a plain number of seconds.
+func (r csvRow) seconds(column string) (int, error) {
+	s := r.get(column)
+	if n, err := strconv.ParseFloat(s, 64); err == nil || s == "" {
+		return int(n), nil
+	}
+	total := 0
+	for _, part := range strings.Fields(s) {
+		n, err := strconv.Atoi(part[:len(part)-1])
+		unit := map[byte]int{'h': 3600, 'm': 60, 's': 1}[part[len(part)-1]]
+		if err != nil || unit == 0 {
+			return 0, &ImportError{r.line, fmt.Sprintf("%s is not a duration: %q", column, s)}
+		}
+		total += n * unit
+	}
+	return total, nil
+}
+
+// clock reads FitNotes' h:mm:ss durations as seconds.
+func (r csvRow) clock(column string) (int, error) {
+	s := r.get(column)
+	if s == "" {
+		return 0, nil
+	}
+	parts := strings.Split(s, ":")
+	total := 0
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || len(parts) > 3 {
+			return 0, &ImportError{r.line, fmt.Sprintf("%s is not a duration: %q", column, s)}
+		}
+		total = total*60 + n
+	}
+	return total, nil
+}
+
+// detectFormat tells the format of an export from the columns of its header.
+func detectFormat(columns map[string]int) string {
+	has := func(names ...string) bool {
+		for _, name := range names {
+			if _, ok := columns[name]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case has("exercise_title", "set_index"):
+		return ImportHevy
+	case has("workout name", "exercise name", "set order"):
+		return ImportStrong
+	case has("date", "exercise", "category"):
+		return ImportFitNotes
+	}
+	return ""
+}
+
+// readRow reads a row of an export in the format into an importRow.
+func readRow(format string, r csvRow, opts ImportOptions) (importRow, error) {
+	row := importRow{line: r.line, unit: opts.SourceUnit}
+	var err error
+	reps, weight, duration := "reps", "weight", 0
+	switch format {
+	case ImportStrong:
+		row.session, row.exercise, row.notes = r.get("workout name"), r.get("exercise name"), r.get("notes")
+		if row.start, err = r.time("date", opts.Location); err != nil {
+			return row, err
+		}
+		column := "duration"
+		if r.has("workout duration") {
+			column = "workout duration"
+		}
+		var length int
+		if length, err = r.seconds(column); err != nil {
+			return row, err
+		}
+		if length > 0 {
+			row.end = row.start.Add(time.Duration(length) * time.Second)
+		}
+		if r.has("weight unit") {
+			row.unit = r.get("weight unit")
+		}
+		if duration, err = r.seconds("seconds"); err != nil {
+			return row, err
+		}
+		if strings.EqualFold(r.get("set order"), "rest timer") {
+			row.restOnly = true
+			row.set.Rest = duration * 1000
+			return row, nil
+		}
+		// the order of a set that isn't a working set is a letter
+		row.set.Type = map[string]string{"w": SetWarmup, "d": SetDrop, "f": SetFailure}[strings.ToLower(r.get("set order"))]
+	case ImportHevy:
+		row.session, row.exercise, row.notes = r.get("title"), r.get("exercise_title"), r.get("exercise_notes")
+		if row.start, err = r.time("start_time", opts.Location); err != nil {
+			return row, err
+		}
+		if row.end, err = r.time("end_time", opts.Location); err != nil {
+			return row, err
+		}
+		weight, row.unit = "weight_kg", UnitKg
+		if r.has("weight_lbs") {
+			weight, row.unit = "weight_lbs", UnitLb
+		}
+		if duration, err = r.seconds("duration_seconds"); err != nil {
+			return row, err
+		}
+		row.set.Type = map[string]string{"warmup": SetWarmup, "dropset": SetDrop, "failure": SetFailure}[strings.ToLower(r.get("set_type"))]
+	case ImportFitNotes:
+		row.session, row.exercise, row.notes = "FitNotes", r.get("exercise"), r.get("comment")
+		if row.start, err = r.time("date", opts.Location); err != nil {
+			return row, err
+		}
+		for _, c := range fitNotesWeights {
+			if r.has(c.column) {
+				weight, row.unit = c.column, c.unit
+				break
+			}
+		}
+		if duration, err = r.clock("time"); err != nil {
+			return row, err
+		}
+	}
+	if row.exercise == "" {
+		return row, &ImportError{r.line, "no exercise name"}
+	}
+	if row.start.IsZero() {
+		return row, &ImportError{r.line, "no date"}
+	}
+	n, err := r.number(reps)
+	if err != nil {
+		return row, err
+	}
+	row.set.Reps = int(n)
+	if row.weight, err = r.number(weight); err != nil {
+		return row, err
+	}
+	row.set.Duration = duration * 1000
+	switch {
+	case row.set.Type != "":
+	case row.set.Reps == 0 && row.set.Duration > 0:
+		row.set.Type = SetTimed
+	default:
+		row.set.Type = SetWorking
+	}
+	return row, nil
+}
+
+// readExport reads the rows of an export, telling its format if need be.
+func readExport(r io.Reader, opts *ImportOptions) ([]importRow, error) {
+	buffered := bufio.NewReader(r)
+	head, _ := buffered.Peek(4096)
+	header := string(head)
+	if i := strings.IndexByte(header, '\n'); i >= 0 {
+		header = header[:i]
+	}
+	in := csv.NewReader(buffered)
+	if strings.Count(header, ";") > strings.Count(header, ",") { // Strong in locales with decimal commas
+		in.Comma = ';'
+	}
+	in.FieldsPerRecord = -1
+	in.LazyQuotes = true
+	names, err := in.Read()
+	if err == io.EOF {
+		return nil, &ImportError{0, "the file is empty"}
+	}
+	if err != nil {
+		return nil, &ImportError{1, err.Error()}
+	}
+	columns := map[string]int{}
+	for i, name := range names {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	detected := detectFormat(columns)
+	if opts.Format == "" {
+		opts.Format = detected
+	}
+	if opts.Format == "" || opts.Format != detected {
+		return nil, &ImportError{1, "not a Strong, Hevy or FitNotes CSV export, or not the format given"}
+	}
+	var rows []importRow
+	for line := 2; ; line++ {
+		fields, err := in.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, &ImportError{line, err.Error()}
+		}
+		row, err := readRow(opts.Format, csvRow{line, columns, fields}, *opts)
+		if err != nil {
+			return nil, err
+		}
+		if row.unit, err = unitOf(row.unit); err != nil {
+			return nil, &ImportError{line, err.Error()}
+		}
+		rows = append(rows, row)
+	}
+}
+
+// matchExercises finds the catalog exercise of each name of an export: the
+// one given in the options, else the one of the name or alias, else the one
+// of the name without the equipment in parentheses that Strong and Hevy add.
+func matchExercises(db Conn, names []string, given map[string]uint64) (map[string]ExerciseDefinitionDB, []string, error) {
+	catalog, err := Catalog(db)
+	if err != nil {
+		return nil, nil, err
+	}
+	var aliases []ExerciseAliasDB
+	if err := db.Collection("exercise_aliases").Find().All(&aliases); err != nil {
+		return nil, nil, err
+	}
+	byID := map[uint64]ExerciseDefinitionDB{}
+	byName := map[string]uint64{}
+	for _, a := range aliases {
+		byName[a.Alias] = a.Definition
+	}
+	for _, def := range catalog {
+		byID[def.ID] = def
+		byName[normalizeName(def.Name)] = def.ID
+	}
+	matched := map[string]ExerciseDefinitionDB{}
+	unmatched := []string{}
+	for _, name := range names {
+		id := given[name]
+		if _, ok := byID[id]; !ok {
+			id = byName[normalizeName(name)]
+		}
+		if i := strings.LastIndex(name, "("); id == 0 && i > 0 && strings.HasSuffix(name, ")") {
+			id = byName[normalizeName(name[:i])]
+		}
+		if id == 0 {
+			unmatched = append(unmatched, name)
+			continue
+		}
+		matched[name] = byID[id]
+	}
+	return matched, unmatched, nil
+}
+
+// Import reads a CSV export of another workout app into the user's sessions.
+// Nothing is saved on a dry run, or while some exercise names match no
+// catalog exercise, which ErrUnmatchedExercises reports along with a result
+// listing them. Sessions already logged are left out, so an export can be
+// imported again after more sessions were added to it.
+func Import(db sqlbuilder.Database, userID uint64, r io.Reader, opts ImportOptions) (ImportResult, error) {
+	var err error
+	if opts.SourceUnit == "" {
+		opts.SourceUnit, err = unitOfUser(db, userID)
+	} else {
+		opts.SourceUnit, err = unitOf(opts.SourceUnit)
+	}
+	if err != nil {
+		return ImportResult{}, err
+	}
+	if opts.Location == nil {
+		opts.Location = time.UTC
+	}
+	rows, err := readExport(r, &opts)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	result := ImportResult{Format: opts.Format, DryRun: opts.DryRun, Workouts: []Workout{}, Duplicates: []string{}}
+
+	// sessions by name and start time, and exercises by name within them, in the order of the export
+	type key struct {
+		name  string
+		start int64
+	}
+	sessions := map[key]int{}
+	exercises := map[key]map[string]int{}
+	var names []string
+	seen := map[string]bool{}
+	for _, row := range rows {
+		k := key{row.session, row.start.Unix()}
+		if _, ok := sessions[k]; !ok {
+			sessions[k] = len(result.Workouts)
+			exercises[k] = map[string]int{}
+			end := row.start // finished, of unknown duration
+			if row.end.After(row.start) {
+				end = row.end
+			}
+			result.Workouts = append(result.Workouts, Workout{
+				WorkoutDB: WorkoutDB{Name: row.session, StartTime: uint64(row.start.Unix()), EndTime: uint64(end.Unix()), User: userID},
+				Exercises: []Exercise{},
+			})
+		}
+		w := &result.Workouts[sessions[k]]
+		if _, ok := exercises[k][row.exercise]; !ok {
+			exercises[k][row.exercise] = len(w.Exercises)
+			w.Exercises = append(w.Exercises, Exercise{Name: row.exercise, Sets: []SetDB{}})
+		}
+		e := &w.Exercises[exercises[k][row.exercise]]
+		if !seen[row.exercise] {
+			seen[row.exercise] = true
+			names = append(names, row.exercise)
+		}
+		if e.Notes == "" {
+			e.Notes = row.notes
+		}
+		if row.restOnly {
+			if len(e.Sets) > 0 {
+				e.Sets[len(e.Sets)-1].Rest = row.set.Rest
+				e.Sets[len(e.Sets)-1].RestExpected = row.set.Rest
+			}
+			continue
+		}
+		// there was no plan, so the set is taken as planned
+		s := row.set
+		s.Order = len(e.Sets)
+		s.Weight = WeightOf(row.weight).FromUnit(row.unit)
+		s.RepsExpected, s.WeightExpected, s.DurationExpected, s.RestExpected = s.Reps, s.Weight, s.Duration, s.Rest
+		if !s.HasRepTarget() {
+			s.RepsExpected = 0
+		}
+		if s.Type == SetDrop && dropParent(e.Sets) < 0 {
+			s.Type = SetWorking
+		}
+		e.Sets = append(e.Sets, s)
+	}
+
+	matched, unmatched, err := matchExercises(db, names, opts.Exercises)
+	if err != nil {
+		return ImportResult{}, err
+	}
+	sort.Strings(unmatched)
+	result.Unmatched = unmatched
+	imported := result.Workouts[:0]
+	for _, w := range result.Workouts {
+		exists, err := db.Collection("workouts").Find(up.Cond{"user": userID, "name": w.Name, "startTime": w.StartTime}).Exists()
+		if err != nil {
+			return ImportResult{}, err
+		}
+		if exists {
+			result.Duplicates = append(result.Duplicates, w.Name+" "+time.Unix(int64(w.StartTime), 0).In(opts.Location).Format("2006-01-02 15:04"))
+			continue
+		}
+		for i := range w.Exercises {
+			e := &w.Exercises[i]
+			if def, ok := matched[e.Name]; ok {
+				e.Definition, e.Name = def.ID, def.Name
+			}
+			result.Sets += len(e.Sets)
+		}
+		imported = append(imported, w)
+	}
+	result.Workouts = imported
+	if len(unmatched) > 0 {
+		return result, ErrUnmatchedExercises
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	err = db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		for i := range result.Workouts {
+			w := &result.Workouts[i]
+			if err := tx.Collection("workouts").InsertReturning(&w.WorkoutDB); err != nil {
+				return err
+			}
+			for j := range w.Exercises {
+				e := &w.Exercises[j]
+				e.Workout = w.ID
+				if err := tx.Collection("workout_exercises").InsertReturning(&e.ExerciseDB); err != nil {
+					return err
+				}
+				for k := range e.Sets {
+					e.Sets[k].Exercise = e.ID
+					if e.Sets[k].Type == SetDrop {
+						e.Sets[k].Parent = e.Sets[dropParent(e.Sets[:k])].ID
+					}
+					if err := tx.Collection("sets").InsertReturning(&e.Sets[k]); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+	return result, nil
+}
+
+// dropParent returns the index of the set a drop set following the sets
+// continues: the last that isn't a warm-up or a drop set itself, or -1 if
+// there is none.
+func dropParent(sets []SetDB) int {
+	for i := len(sets) - 1; i >= 0; i-- {
+		if sets[i].IsWork() && sets[i].Type != SetDrop {
+			return i
+		}
+	}
+	return -1
+}
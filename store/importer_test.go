@@ -0,0 +1,273 @@
+package store
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	up "upper.io/db.v3"
+)
+
+// Small exports of each app, as they write them.
+const (
+	strongCSV = `Date,Workout Name,Duration,Exercise Name,Set Order,Weight,Reps,Distance,Seconds,Notes,Workout Notes,RPE
+2019-02-01 18:00:00,Evening,1h 5m,Squat (Barbell),W,60,5,0,0,,,
+2019-02-01 18:00:00,Evening,1h 5m,Squat (Barbell),1,100,5,0,0,felt good,,
+2019-02-01 18:00:00,Evening,1h 5m,Squat (Barbell),Rest Timer,0,0,0,180,,,
+2019-02-01 18:00:00,Evening,1h 5m,Squat (Barbell),F,100,3,0,0,,,
+2019-02-01 18:00:00,Evening,1h 5m,Plank,1,0,0,0,60,,,
+`
+	// Strong in a locale with decimal commas, which says its unit
+	strongSemicolonCSV = `Date;Workout Name;Duration;Exercise Name;Set Order;Weight;Weight Unit;Reps;Seconds;Notes
+2019-02-01 18:00:00;Evening;30m;Bench Press (Barbell);1;62,5;lbs;5;0;
+`
+	hevyCSV = `title,start_time,end_time,description,exercise_title,superset_id,exercise_notes,set_index,set_type,weight_lbs,reps,distance_miles,duration_seconds,rpe
+Push,"1 Feb 2019, 18:00","1 Feb 2019, 19:00",,Flat Barbell Bench Press,,,0,warmup,95,10,,,
+Push,"1 Feb 2019, 18:00","1 Feb 2019, 19:00",,Flat Barbell Bench Press,,,1,normal,135,5,,,
+Push,"1 Feb 2019, 18:00","1 Feb 2019, 19:00",,Flat Barbell Bench Press,,,2,dropset,95,8,,,
+`
+	fitNotesCSV = `Date,Exercise,Category,Weight (kgs),Reps,Distance,Distance Unit,Time,Comment
+2019-02-01,OHP,Shoulders,40.0,5,,,,
+2019-02-01,Plank,Abs,,,,,0:01:30,
+`
+	// both units, of which the first of fitNotesWeights is read
+	fitNotesTwoWeightsCSV = `Date,Exercise,Category,Weight (lbs),Reps,Weight (kgs)
+2019-02-01,OHP,Shoulders,88.2,5,40
+`
+)
+
+func TestReadExport(t *testing.T) {
+	day := time.Date(2019, 2, 1, 18, 0, 0, 0, time.UTC)
+	// the fields of an importRow that come from the file
+	type row struct {
+		session, exercise, notes string
+		start, end               time.Time
+		weight                   float64
+		unit                     string
+		reps, duration, rest     int
+		typ                      string
+		restOnly                 bool
+	}
+	for _, c := range []struct {
+		name       string
+		csv        string
+		format     string
+		sourceUnit string
+		want       []row
+	}{
+		{"strong", strongCSV, ImportStrong, UnitKg, []row{
+			{"Evening", "Squat (Barbell)", "", day, day.Add(65 * time.Minute), 60, UnitKg, 5, 0, 0, SetWarmup, false},
+			{"Evening", "Squat (Barbell)", "felt good", day, day.Add(65 * time.Minute), 100, UnitKg, 5, 0, 0, SetWorking, false},
+			{"Evening", "Squat (Barbell)", "", day, day.Add(65 * time.Minute), 0, UnitKg, 0, 0, 180000, "", true},
+			{"Evening", "Squat (Barbell)", "", day, day.Add(65 * time.Minute), 100, UnitKg, 3, 0, 0, SetFailure, false},
+			{"Evening", "Plank", "", day, day.Add(65 * time.Minute), 0, UnitKg, 0, 60000, 0, SetTimed, false},
+		}},
+		{"strong in pounds by default", strongCSV[:strings.Index(strongCSV, "\n2019")+1] + "2019-02-01 18:00:00,Evening,,Deadlift (Barbell),1,225,5,0,0,,,\n", ImportStrong, UnitLb, []row{
+			{"Evening", "Deadlift (Barbell)", "", day, time.Time{}, 225, UnitLb, 5, 0, 0, SetWorking, false},
+		}},
+		{"strong with semicolons", strongSemicolonCSV, ImportStrong, UnitKg, []row{
+			{"Evening", "Bench Press (Barbell)", "", day, day.Add(30 * time.Minute), 62.5, UnitLb, 5, 0, 0, SetWorking, false},
+		}},
+		{"hevy", hevyCSV, ImportHevy, UnitKg, []row{
+			{"Push", "Flat Barbell Bench Press", "", day, day.Add(time.Hour), 95, UnitLb, 10, 0, 0, SetWarmup, false},
+			{"Push", "Flat Barbell Bench Press", "", day, day.Add(time.Hour), 135, UnitLb, 5, 0, 0, SetWorking, false},
+			{"Push", "Flat Barbell Bench Press", "", day, day.Add(time.Hour), 95, UnitLb, 8, 0, 0, SetDrop, false},
+		}},
+		{"fitnotes", fitNotesCSV, ImportFitNotes, UnitLb, []row{
+			{"FitNotes", "OHP", "", day.Truncate(24 * time.Hour), time.Time{}, 40, UnitKg, 5, 0, 0, SetWorking, false},
+			{"FitNotes", "Plank", "", day.Truncate(24 * time.Hour), time.Time{}, 0, UnitKg, 0, 90000, 0, SetTimed, false},
+		}},
+		{"fitnotes with two weight columns", fitNotesTwoWeightsCSV, ImportFitNotes, UnitLb, []row{
+			{"FitNotes", "OHP", "", day.Truncate(24 * time.Hour), time.Time{}, 40, UnitKg, 5, 0, 0, SetWorking, false},
+		}},
+	} {
+		opts := ImportOptions{SourceUnit: c.sourceUnit, Location: time.UTC}
+		rows, err := readExport(strings.NewReader(c.csv), &opts)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if opts.Format != c.format {
+			t.Errorf("%s: format %q, want %q", c.name, opts.Format, c.format)
+		}
+		if len(rows) != len(c.want) {
+			t.Errorf("%s: %d rows, want %d", c.name, len(rows), len(c.want))
+			continue
+		}
+		for i, r := range rows {
+			got := row{r.session, r.exercise, r.notes, r.start, r.end, r.weight, r.unit,
+				r.set.Reps, r.set.Duration, r.set.Rest, r.set.Type, r.restOnly}
+			if got != c.want[i] {
+				t.Errorf("%s: row %d is\n%+v, want\n%+v", c.name, i+1, got, c.want[i])
+			}
+		}
+	}
+}
+
+func TestReadExportErrors(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		csv    string
+		format string
+		line   int
+	}{
+		{"empty", "", "", 0},
+		{"unknown header", "a,b,c\n1,2,3\n", "", 1},
+		{"other format given", fitNotesCSV, ImportStrong, 1},
+		{"bad weight", "Date,Exercise,Category,Weight (kgs),Reps\n2019-02-01,OHP,Shoulders,heavy,5\n", "", 2},
+		{"bad date", "Date,Exercise,Category,Weight (kgs),Reps\nyesterday,OHP,Shoulders,40,5\n", "", 2},
+		{"no exercise", "Date,Exercise,Category,Weight (kgs),Reps\n2019-02-01,,Shoulders,40,5\n", "", 2},
+		{"bad duration", strongCSV[:strings.Index(strongCSV, "\n")+1] + "2019-02-01 18:00:00,Evening,ages,Squat,1,100,5,0,0,,,\n", "", 2},
+	} {
+		opts := ImportOptions{Format: c.format, SourceUnit: UnitKg, Location: time.UTC}
+		_, err := readExport(strings.NewReader(c.csv), &opts)
+		ierr, ok := err.(*ImportError)
+		if !ok {
+			t.Errorf("%s: error %v, want an ImportError", c.name, err)
+			continue
+		}
+		if ierr.Line != c.line {
+			t.Errorf("%s: error on line %d, want %d: %s", c.name, ierr.Line, c.line, ierr)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	if _, err := SeedExercises(db); err != nil {
+		t.Fatal(err)
+	}
+	user, err := CreateUser(db, "alice", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	definition := func(name string) uint64 {
+		var def ExerciseDefinitionDB
+		if err := db.Collection("exercise_definitions").Find(up.Cond{"name": name}).One(&def); err != nil {
+			t.Fatal(err)
+		}
+		return def.ID
+	}
+
+	for _, c := range []struct {
+		name      string
+		csv       string
+		exercises []string // the catalog exercise of each exercise of the session
+		duration  uint64   // of the session, 0 if unknown
+		sets      int
+	}{
+		// by name without the equipment
+		{"strong", strongCSV, []string{"Squat", "Plank"}, 65 * 60, 4},
+		// by alias
+		{"hevy", hevyCSV, []string{"Bench Press"}, 60 * 60, 3},
+		{"fitnotes", fitNotesCSV, []string{"Overhead Press", "Plank"}, 0, 2},
+	} {
+		result, err := Import(db, user.ID, strings.NewReader(c.csv), ImportOptions{})
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		if len(result.Workouts) != 1 || result.Sets != c.sets {
+			t.Errorf("%s: imported %d sessions of %d sets, want 1 of %d", c.name, len(result.Workouts), result.Sets, c.sets)
+			continue
+		}
+		w := result.Workouts[0]
+		if got := w.EndTime - w.StartTime; got != c.duration || w.HasDuration() != (c.duration > 0) || w.EndTime == 0 {
+			t.Errorf("%s: session from %d to %d, want finished after %d seconds", c.name, w.StartTime, w.EndTime, c.duration)
+		}
+		for i, e := range w.Exercises {
+			if i >= len(c.exercises) || e.Definition != definition(c.exercises[i]) {
+				t.Errorf("%s: exercise %d is %q, want %q", c.name, i+1, e.Name, c.exercises)
+			}
+		}
+		loaded, err := LoadWorkout(db, user.ID, w.ID)
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+		sets := 0
+		for _, e := range loaded.Exercises {
+			sets += len(e.Sets)
+		}
+		if sets != c.sets {
+			t.Errorf("%s: %d sets saved, want %d", c.name, sets, c.sets)
+		}
+
+		// the same export again is all duplicates
+		again, err := Import(db, user.ID, strings.NewReader(c.csv), ImportOptions{})
+		if err != nil {
+			t.Errorf("%s again: %s", c.name, err)
+			continue
+		}
+		if len(again.Workouts) != 0 || len(again.Duplicates) != 1 {
+			t.Errorf("%s again: %d sessions imported and %d duplicates, want 0 and 1", c.name, len(again.Workouts), len(again.Duplicates))
+		}
+	}
+	if n := count(t, db, "workouts", up.Cond{"user": user.ID}); n != 3 {
+		t.Errorf("%d sessions saved, want 3", n)
+	}
+
+	// the drop set of the Hevy export follows its working set
+	var drop, parent SetDB
+	if err := db.Collection("sets").Find(up.Cond{"type": SetDrop}).One(&drop); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Collection("sets").Find(drop.Parent).One(&parent); err != nil {
+		t.Fatalf("parent of the drop set: %s", err)
+	}
+	if parent.Type != SetWorking || parent.Weight.ToUnit(UnitLb) != 135000 || drop.Weight.ToUnit(UnitLb) != 95000 {
+		t.Errorf("drop set of %s lb after a %s set of %s lb, want 95 lb after a working set of 135 lb",
+			drop.Weight.ToUnit(UnitLb), parent.Type, parent.Weight.ToUnit(UnitLb))
+	}
+}
+
+func TestImportUnmatched(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	if _, err := SeedExercises(db); err != nil {
+		t.Fatal(err)
+	}
+	user, err := CreateUser(db, "alice", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csv := "Date,Exercise,Category,Weight (kgs),Reps\n2019-02-01,Zercher Squat,Legs,60,5\n2019-02-01,OHP,Shoulders,40,5\n"
+
+	result, err := Import(db, user.ID, strings.NewReader(csv), ImportOptions{})
+	if err != ErrUnmatchedExercises {
+		t.Fatalf("import: %v, want %v", err, ErrUnmatchedExercises)
+	}
+	if len(result.Unmatched) != 1 || result.Unmatched[0] != "Zercher Squat" {
+		t.Errorf("unmatched %q, want [Zercher Squat]", result.Unmatched)
+	}
+	if n := count(t, db, "workouts", up.Cond{"user": user.ID}); n != 0 {
+		t.Fatalf("%d sessions saved with an unmatched exercise, want 0", n)
+	}
+
+	// matched by hand, or by an alias added since
+	var squat ExerciseDefinitionDB
+	if err := db.Collection("exercise_definitions").Find(up.Cond{"name": "Squat"}).One(&squat); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Import(db, user.ID, strings.NewReader(csv), ImportOptions{DryRun: true, Exercises: map[string]uint64{"Zercher Squat": squat.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Workouts) != 1 || result.Sets != 2 {
+		t.Errorf("dry run would import %d sessions of %d sets, want 1 of 2", len(result.Workouts), result.Sets)
+	}
+	if n := count(t, db, "workouts", up.Cond{"user": user.ID}); n != 0 {
+		t.Fatalf("%d sessions saved on a dry run, want 0", n)
+	}
+	if _, err := AddAlias(db, "zercher squat", squat.ID); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Import(db, user.ID, strings.NewReader(csv), ImportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := result.Workouts[0].Exercises[0]; e.Definition != squat.ID || e.Name != "Squat" {
+		t.Errorf("Zercher Squat imported as %q (%d), want Squat (%d)", e.Name, e.Definition, squat.ID)
+	}
+}
@@ -0,0 +1,202 @@
+package store
+
+import (
+	"errors"
+	"time"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// A session is performed live: the workout is started, each set is started
+// and completed in turn, and finally the workout is finished. Timestamps of
+// sets are unix milliseconds, those of workouts unix seconds.
+
+var (
+	ErrTemplateLive    = errors.New("a template cannot be performed, start a session from it instead")
+	ErrSetStarted      = errors.New("the set was already started")
+	ErrSetNotStarted   = errors.New("the set hasn't been started")
+	ErrSetCompleted    = errors.New("the set was already completed")
+	ErrSetInProgress   = errors.New("another set is in progress, complete it first")
+	ErrWorkoutNotEmpty = errors.New("the workout already has sets started")
+)
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// workoutSets returns every set of the workout.
+func workoutSets(db Conn, workoutID uint64) ([]SetDB, error) {
+	var exercises []ExerciseDB
+	if err := db.Collection("workout_exercises").Find(up.Cond{"workout": workoutID}).All(&exercises); err != nil {
+		return nil, err
+	}
+	sets := []SetDB{}
+	if len(exercises) == 0 {
+		return sets, nil
+	}
+	ids := make([]uint64, len(exercises))
+	for i, e := range exercises {
+		ids[i] = e.ID
+	}
+	err := db.Collection("sets").Find(up.Cond{"exercise IN": ids}).All(&sets)
+	return sets, err
+}
+
+// liveWorkout returns the user's workout if it is a session still in progress.
+func liveWorkout(db Conn, userID, workoutID uint64) (WorkoutDB, error) {
+	workout, err := editableWorkout(db, userID, workoutID)
+	if err != nil {
+		return WorkoutDB{}, err
+	}
+	if workout.IsTemplate() {
+		return WorkoutDB{}, ErrTemplateLive
+	}
+	return workout, nil
+}
+
+// StartWorkout restarts the clock of a session that was created ahead of
+// time: its start time becomes now. Once a set has been started, the start
+// time is fixed.
+func StartWorkout(db sqlbuilder.Database, userID, workoutID uint64) (WorkoutDB, error) {
+	var workout WorkoutDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		var err error
+		if workout, err = liveWorkout(tx, userID, workoutID); err != nil {
+			return err
+		}
+		sets, err := workoutSets(tx, workoutID)
+		if err != nil {
+			return err
+		}
+		for _, s := range sets {
+			if s.StartedAt != 0 {
+				return ErrWorkoutNotEmpty
+			}
+		}
+		workout.StartTime = uint64(time.Now().Unix())
+		return tx.Collection("workouts").Find(workoutID).Update(workout)
+	})
+	if err != nil {
+		return WorkoutDB{}, err
+	}
+	return workout, nil
+}
+
+// StartSet marks a set of a session as started now. Only one set of a
+// session is in progress at a time. Starting a set ends the rest timer, which
+// records the rest taken after the previous set.
+func StartSet(db sqlbuilder.Database, userID, setID uint64) (SetDB, error) {
+	var set SetDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		var err error
+		if set, err = UserSet(tx, userID, setID); err != nil {
+			return err
+		}
+		exercise, err := UserExercise(tx, userID, set.Exercise)
+		if err != nil {
+			return err
+		}
+		if _, err := liveWorkout(tx, userID, exercise.Workout); err != nil {
+			return err
+		}
+		if set.StartedAt != 0 {
+			return ErrSetStarted
+		}
+		sets, err := workoutSets(tx, exercise.Workout)
+		if err != nil {
+			return err
+		}
+		for _, s := range sets {
+			if s.StartedAt != 0 && s.CompletedAt == 0 {
+				return ErrSetInProgress
+			}
+		}
+		now := nowMillis()
+		if _, _, err := endRest(tx, exercise.Workout, now); err != nil {
+			return err
+		}
+		set.StartedAt = now
+		return tx.Collection("sets").Find(set.ID).Update(set)
+	})
+	if err != nil {
+		return SetDB{}, err
+	}
+	return set, nil
+}
+
+// CompleteSet marks a started set as completed now and records how long it
+// took, then starts the rest timer. Reps and weight not entered yet are
+// assumed to be as expected. The set comes back with the personal records it set.
+func CompleteSet(db sqlbuilder.Database, userID, setID uint64) (SetDB, error) {
+	var set SetDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		var err error
+		if set, err = UserSet(tx, userID, setID); err != nil {
+			return err
+		}
+		exercise, err := UserExercise(tx, userID, set.Exercise)
+		if err != nil {
+			return err
+		}
+		workout, err := liveWorkout(tx, userID, exercise.Workout)
+		if err != nil {
+			return err
+		}
+		if set.StartedAt == 0 {
+			return ErrSetNotStarted
+		}
+		if set.CompletedAt != 0 {
+			return ErrSetCompleted
+		}
+		set.CompletedAt = nowMillis()
+		set.Duration = int(set.CompletedAt - set.StartedAt)
+		if set.Reps == 0 && set.Weight == 0 {
+			set.Reps = set.RepsExpected
+			set.Weight = set.WeightExpected
+		}
+		if err := tx.Collection("sets").Find(set.ID).Update(set); err != nil {
+			return err
+		}
+		if set, err = withRecords(tx, workout, exercise, set); err != nil {
+			return err
+		}
+		return startRest(tx, exercise.Workout, set)
+	})
+	if err != nil {
+		return SetDB{}, err
+	}
+	return set, nil
+}
+
+// FinishWorkout ends a session now. From then on, the workout is read-only.
+// A set still in progress is left uncompleted, and a rest still running is
+// stopped without being recorded, as no set followed it.
+func FinishWorkout(db sqlbuilder.Database, userID, workoutID uint64) (WorkoutDB, error) {
+	var workout WorkoutDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		var err error
+		if workout, err = UserWorkout(tx, userID, workoutID); err != nil {
+			return err
+		}
+		if workout.IsTemplate() {
+			return ErrFinishTemplate
+		}
+		if workout.EndTime != 0 {
+			return ErrWorkoutFinished
+		}
+		workout.EndTime = uint64(time.Now().Unix())
+		if workout.EndTime < workout.StartTime { // started ahead of the clock
+			workout.EndTime = workout.StartTime
+		}
+		if err := tx.Collection("workouts").Find(workoutID).Update(workout); err != nil {
+			return err
+		}
+		running := tx.Collection("rest_timers").Find(up.Cond{"workout": workoutID, "endedAt": 0})
+		return running.Update(up.Cond{"endedAt": nowMillis()})
+	})
+	if err != nil {
+		return WorkoutDB{}, err
+	}
+	return workout, nil
+}
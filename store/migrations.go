@@ -0,0 +1,870 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+	"upper.io/db.v3/postgresql"
+	"upper.io/db.v3/sqlite"
+)
+
+// Dialect identifies which SQL backend a database is using. DDL that
+// differs between SQLite (dev) and Postgres (production) is generated per dialect.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgresql"
+)
+
+// DialectOf reports the dialect of an open database.
+func DialectOf(db sqlbuilder.Database) (Dialect, error) {
+	switch db.ConnectionURL().(type) {
+	case sqlite.ConnectionURL, *sqlite.ConnectionURL:
+		return SQLite, nil
+	case postgresql.ConnectionURL, *postgresql.ConnectionURL:
+		return Postgres, nil
+	}
+	return "", fmt.Errorf("unsupported database connection: %T", db.ConnectionURL())
+}
+
+// primaryKey is the column definition of an auto-increment ID.
+func (d Dialect) primaryKey() string {
+	if d == Postgres {
+		return "BIGSERIAL PRIMARY KEY"
+	}
+	return "INTEGER PRIMARY KEY"
+}
+
+// bigint is the column type of IDs referencing other tables and of unix times.
+func (d Dialect) bigint() string {
+	if d == Postgres {
+		return "BIGINT"
+	}
+	return "INTEGER"
+}
+
+// Migration is one step of the schema history. Up moves the schema from
+// Version-1 to Version and Down undoes it. A Down returning no statements
+// means the step can't be rolled back in that dialect.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(d Dialect) []string
+	Down    func(d Dialect) []string
+}
+
+// ErrIrreversible is returned when rolling back past a migration that has no down step.
+var ErrIrreversible = errors.New("migration cannot be rolled back")
+
+// Identifiers are quoted throughout: Postgres folds unquoted names to lower case
+// (breaking "startTime") and reserves "user" and "order".
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create users, workouts, exercises and sets",
+		Up: func(d Dialect) []string {
+			return []string{
+				`CREATE TABLE IF NOT EXISTS "users"(
+					"id"       ` + d.primaryKey() + `,
+					"name"     TEXT NOT NULL,
+					"cookie"   TEXT NOT NULL,
+					"password" TEXT NOT NULL
+				)`,
+				`CREATE TABLE IF NOT EXISTS "workouts"(
+					"id"        ` + d.primaryKey() + `,
+					"name"      TEXT NOT NULL,
+					"startTime" ` + d.bigint() + ` NOT NULL,
+					"endTime"   ` + d.bigint() + ` NOT NULL,
+					"user"      ` + d.bigint() + ` NOT NULL,
+					FOREIGN KEY ("user") REFERENCES "users"("id")
+				)`,
+				`CREATE TABLE IF NOT EXISTS "exercises"(
+					"id"      ` + d.primaryKey() + `,
+					"name"    TEXT NOT NULL,
+					"notes"   TEXT NOT NULL,
+					"workout" ` + d.bigint() + ` NOT NULL,
+					FOREIGN KEY ("workout") REFERENCES "workouts"("id")
+				)`,
+				`CREATE TABLE IF NOT EXISTS "sets"(
+					"id"               ` + d.primaryKey() + `,
+					"order"            INTEGER NOT NULL, /* first is 0, second is 1, etc. */
+					"reps"             INTEGER NOT NULL,
+					"weight"           INTEGER NOT NULL,
+					"duration"         INTEGER NOT NULL,
+					"rest"             INTEGER NOT NULL,
+					"repsExpected"     INTEGER NOT NULL,
+					"weightExpected"   INTEGER NOT NULL,
+					"durationExpected" INTEGER NOT NULL,
+					"restExpected"     INTEGER NOT NULL,
+					"exercise"         ` + d.bigint() + ` NOT NULL,
+					FOREIGN KEY ("exercise") REFERENCES "exercises"("id")
+				)`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			return []string{
+				`DROP TABLE "sets"`,
+				`DROP TABLE "exercises"`,
+				`DROP TABLE "workouts"`,
+				`DROP TABLE "users"`,
+			}
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create exercise_definitions catalog",
+		Up: func(d Dialect) []string {
+			return []string{
+				`CREATE TABLE "exercise_definitions"(
+					"id"          ` + d.primaryKey() + `,
+					"name"        TEXT NOT NULL UNIQUE,
+					"notes"       TEXT NOT NULL,
+					"defaultSets" INTEGER NOT NULL,
+					"defaultReps" INTEGER NOT NULL,
+					"defaultRest" INTEGER NOT NULL  /* milliseconds */
+				)`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			return []string{`DROP TABLE "exercise_definitions"`}
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create sessions, drop users.cookie",
+		Up: func(d Dialect) []string {
+			stmts := []string{
+				`CREATE TABLE "sessions"(
+					"id"        ` + d.primaryKey() + `,
+					"user"      ` + d.bigint() + ` NOT NULL,
+					"tokenHash" TEXT NOT NULL UNIQUE,
+					"userAgent" TEXT NOT NULL,
+					"createdAt" ` + d.bigint() + ` NOT NULL,
+					"rotatedAt" ` + d.bigint() + ` NOT NULL,
+					"expiresAt" ` + d.bigint() + ` NOT NULL,
+					FOREIGN KEY ("user") REFERENCES "users"("id")
+				)`,
+				`CREATE INDEX "sessions_user" ON "sessions"("user")`,
+			}
+			if d == Postgres {
+				return append(stmts, `ALTER TABLE "users" DROP COLUMN "cookie"`)
+			}
+			// this SQLite version has no DROP COLUMN, so rebuild the table
+			return append(stmts,
+				`CREATE TABLE "users_new"(
+					"id"       INTEGER PRIMARY KEY,
+					"name"     TEXT NOT NULL,
+					"password" TEXT NOT NULL
+				)`,
+				`INSERT INTO "users_new"("id", "name", "password") SELECT "id", "name", "password" FROM "users"`,
+				`DROP TABLE "users"`,
+				`ALTER TABLE "users_new" RENAME TO "users"`,
+			)
+		},
+		Down: func(d Dialect) []string {
+			if d == Postgres {
+				return []string{
+					`DROP TABLE "sessions"`,
+					`ALTER TABLE "users" ADD COLUMN "cookie" TEXT NOT NULL DEFAULT ''`,
+					`ALTER TABLE "users" ALTER COLUMN "cookie" DROP DEFAULT`,
+				}
+			}
+			// rebuilt as version 1 created it, the cookies lost
+			return []string{
+				`DROP TABLE "sessions"`,
+				`CREATE TABLE "users_new"(
+					"id"       INTEGER PRIMARY KEY,
+					"name"     TEXT NOT NULL,
+					"cookie"   TEXT NOT NULL,
+					"password" TEXT NOT NULL
+				)`,
+				`INSERT INTO "users_new"("id", "name", "cookie", "password") SELECT "id", "name", '', "password" FROM "users"`,
+				`DROP TABLE "users"`,
+				`ALTER TABLE "users_new" RENAME TO "users"`,
+			}
+		},
+	},
+	{
+		Version: 4,
+		Name:    "add users.role, create audit_log",
+		Up: func(d Dialect) []string {
+			return []string{
+				`ALTER TABLE "users" ADD COLUMN "role" TEXT NOT NULL DEFAULT 'user'`,
+				`CREATE TABLE "audit_log"(
+					"id"       ` + d.primaryKey() + `,
+					"admin"    ` + d.bigint() + ` NOT NULL,
+					"action"   TEXT NOT NULL,
+					"table"    TEXT NOT NULL,
+					"recordID" ` + d.bigint() + ` NOT NULL,
+					"detail"   TEXT NOT NULL,
+					"time"     ` + d.bigint() + ` NOT NULL
+				)`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			if d == Postgres {
+				return []string{
+					`DROP TABLE "audit_log"`,
+					`ALTER TABLE "users" DROP COLUMN "role"`,
+				}
+			}
+			return []string{
+				`DROP TABLE "audit_log"`,
+				`CREATE TABLE "users_new"(
+					"id"       INTEGER PRIMARY KEY,
+					"name"     TEXT NOT NULL,
+					"password" TEXT NOT NULL
+				)`,
+				`INSERT INTO "users_new"("id", "name", "password") SELECT "id", "name", "password" FROM "users"`,
+				`DROP TABLE "users"`,
+				`ALTER TABLE "users_new" RENAME TO "users"`,
+			}
+		},
+	},
+	{
+		Version: 5,
+		Name:    "cascade deletes from users to workouts, exercises, sets and sessions",
+		Up: func(d Dialect) []string {
+			return foreignKeys(d, "ON DELETE CASCADE")
+		},
+		Down: func(d Dialect) []string {
+			return foreignKeys(d, "")
+		},
+	},
+	{
+		Version: 6,
+		Name:    "split exercises into the exercise_definitions catalog and workout_exercises",
+		Up: func(d Dialect) []string {
+			stmts := []string{
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "muscleGroups" TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "equipment" TEXT NOT NULL DEFAULT ''`,
+				// every exercise name in use becomes a catalog entry, unless it already is one
+				`INSERT INTO "exercise_definitions"("name", "notes", "defaultSets", "defaultReps", "defaultRest")
+					SELECT DISTINCT "name", '', 3, 0, 0 FROM "exercises"
+					WHERE "name" NOT IN (SELECT "name" FROM "exercise_definitions")`,
+				`CREATE TABLE "workout_exercises"(
+					"id"         ` + d.primaryKey() + `,
+					"workout"    ` + d.bigint() + ` NOT NULL,
+					"definition" ` + d.bigint() + ` NOT NULL,
+					"notes"      TEXT NOT NULL,
+					FOREIGN KEY ("workout") REFERENCES "workouts"("id") ON DELETE CASCADE,
+					FOREIGN KEY ("definition") REFERENCES "exercise_definitions"("id")
+				)`,
+				`CREATE INDEX "workout_exercises_workout" ON "workout_exercises"("workout")`,
+				// IDs are kept, so sets still point at the same exercise
+				`INSERT INTO "workout_exercises"("id", "workout", "definition", "notes")
+					SELECT e."id", e."workout", d."id", e."notes"
+					FROM "exercises" e JOIN "exercise_definitions" d ON d."name" = e."name"`,
+			}
+			stmts = append(stmts, setsReference(d, "workout_exercises")...)
+			stmts = append(stmts, `DROP TABLE "exercises"`)
+			if d == Postgres {
+				stmts = append(stmts, resetSequence("workout_exercises"))
+			}
+			return stmts
+		},
+		Down: func(d Dialect) []string {
+			stmts := []string{
+				`CREATE TABLE "exercises"(
+					"id"      ` + d.primaryKey() + `,
+					"name"    TEXT NOT NULL,
+					"notes"   TEXT NOT NULL,
+					"workout" ` + d.bigint() + ` NOT NULL,
+					FOREIGN KEY ("workout") REFERENCES "workouts"("id") ON DELETE CASCADE
+				)`,
+				`INSERT INTO "exercises"("id", "name", "notes", "workout")
+					SELECT e."id", d."name", e."notes", e."workout"
+					FROM "workout_exercises" e JOIN "exercise_definitions" d ON d."id" = e."definition"`,
+			}
+			stmts = append(stmts, setsReference(d, "exercises")...)
+			stmts = append(stmts, `DROP TABLE "workout_exercises"`)
+			if d == Postgres {
+				return append(stmts,
+					resetSequence("exercises"),
+					`ALTER TABLE "exercise_definitions" DROP COLUMN "muscleGroups", DROP COLUMN "equipment"`,
+				)
+			}
+			const columns = `"id", "name", "notes", "defaultSets", "defaultReps", "defaultRest"`
+			return append(stmts,
+				`CREATE TABLE "exercise_definitions_new"(
+					"id"          INTEGER PRIMARY KEY,
+					"name"        TEXT NOT NULL UNIQUE,
+					"notes"       TEXT NOT NULL,
+					"defaultSets" INTEGER NOT NULL,
+					"defaultReps" INTEGER NOT NULL,
+					"defaultRest" INTEGER NOT NULL
+				)`,
+				`INSERT INTO "exercise_definitions_new"(`+columns+`) SELECT `+columns+` FROM "exercise_definitions"`,
+				`DROP TABLE "exercise_definitions"`,
+				`ALTER TABLE "exercise_definitions_new" RENAME TO "exercise_definitions"`,
+			)
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add sets.startedAt and sets.completedAt",
+		Up: func(d Dialect) []string {
+			return []string{
+				`ALTER TABLE "sets" ADD COLUMN "startedAt" ` + d.bigint() + ` NOT NULL DEFAULT 0 /* unix milliseconds */`,
+				`ALTER TABLE "sets" ADD COLUMN "completedAt" ` + d.bigint() + ` NOT NULL DEFAULT 0 /* unix milliseconds */`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			if d == Postgres {
+				return []string{`ALTER TABLE "sets" DROP COLUMN "startedAt", DROP COLUMN "completedAt"`}
+			}
+			// rebuilding sets drops the new columns
+			return setsReference(d, "workout_exercises")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "create rest_timers",
+		Up: func(d Dialect) []string {
+			return []string{
+				`CREATE TABLE "rest_timers"(
+					"workout"   ` + d.bigint() + ` PRIMARY KEY,
+					"set"       ` + d.bigint() + ` NOT NULL,
+					"startedAt" ` + d.bigint() + ` NOT NULL, /* unix milliseconds */
+					"duration"  ` + d.bigint() + ` NOT NULL, /* milliseconds */
+					"endedAt"   ` + d.bigint() + ` NOT NULL, /* unix milliseconds, 0 while running */
+					FOREIGN KEY ("workout") REFERENCES "workouts"("id") ON DELETE CASCADE,
+					FOREIGN KEY ("set") REFERENCES "sets"("id") ON DELETE CASCADE
+				)`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			return []string{`DROP TABLE "rest_timers"`}
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add progression settings to exercise_definitions",
+		Up: func(d Dialect) []string {
+			return []string{
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "progression" TEXT NOT NULL DEFAULT ''`,
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "increment" INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "repsMax" INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "deloadAfter" INTEGER NOT NULL DEFAULT 0`,
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "deloadPercent" INTEGER NOT NULL DEFAULT 0`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			if d == Postgres {
+				return []string{`ALTER TABLE "exercise_definitions" DROP COLUMN "progression", DROP COLUMN "increment",
+					DROP COLUMN "repsMax", DROP COLUMN "deloadAfter", DROP COLUMN "deloadPercent"`}
+			}
+			const columns = `"id", "name", "notes", "defaultSets", "defaultReps", "defaultRest", "muscleGroups", "equipment"`
+			return []string{
+				`CREATE TABLE "exercise_definitions_new"(
+					"id"           INTEGER PRIMARY KEY,
+					"name"         TEXT NOT NULL UNIQUE,
+					"notes"        TEXT NOT NULL,
+					"defaultSets"  INTEGER NOT NULL,
+					"defaultReps"  INTEGER NOT NULL,
+					"defaultRest"  INTEGER NOT NULL,
+					"muscleGroups" TEXT NOT NULL DEFAULT '',
+					"equipment"    TEXT NOT NULL DEFAULT ''
+				)`,
+				`INSERT INTO "exercise_definitions_new"(` + columns + `) SELECT ` + columns + ` FROM "exercise_definitions"`,
+				`DROP TABLE "exercise_definitions"`,
+				`ALTER TABLE "exercise_definitions_new" RENAME TO "exercise_definitions"`,
+			}
+		},
+	},
+	{
+		Version: 10,
+		Name:    "index the history of an exercise across workouts",
+		Up: func(d Dialect) []string {
+			return []string{
+				`CREATE INDEX "workouts_user" ON "workouts"("user", "startTime")`,
+				`CREATE INDEX "workout_exercises_definition" ON "workout_exercises"("definition", "workout")`,
+				`CREATE INDEX "sets_exercise" ON "sets"("exercise", "order")`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			return []string{
+				`DROP INDEX "workouts_user"`,
+				`DROP INDEX "workout_exercises_definition"`,
+				`DROP INDEX "sets_exercise"`,
+			}
+		},
+	},
+	{
+		Version: 11,
+		Name:    "create exercise_aliases",
+		Up: func(d Dialect) []string {
+			return []string{
+				`CREATE TABLE "exercise_aliases"(
+					"alias"      TEXT PRIMARY KEY, /* normalized, see normalizeName */
+					"definition" ` + d.bigint() + ` NOT NULL,
+					FOREIGN KEY ("definition") REFERENCES "exercise_definitions"("id") ON DELETE CASCADE
+				)`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			return []string{`DROP TABLE "exercise_aliases"`}
+		},
+	},
+	{
+		Version: 12,
+		Name:    "store weights in grams, add users.unit and users.plateIncrement",
+		Up: func(d Dialect) []string {
+			return []string{
+				// weights were whole kilograms
+				`UPDATE "sets" SET "weight" = "weight" * 1000, "weightExpected" = "weightExpected" * 1000`,
+				`UPDATE "exercise_definitions" SET "increment" = "increment" * 1000`,
+				`ALTER TABLE "users" ADD COLUMN "unit" TEXT NOT NULL DEFAULT 'kg'`,
+				`ALTER TABLE "users" ADD COLUMN "plateIncrement" INTEGER NOT NULL DEFAULT 2500 /* thousandths of unit */`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			stmts := []string{
+				`UPDATE "sets" SET "weight" = CAST(ROUND("weight" / 1000.0) AS INTEGER),
+					"weightExpected" = CAST(ROUND("weightExpected" / 1000.0) AS INTEGER)`,
+				`UPDATE "exercise_definitions" SET "increment" = CAST(ROUND("increment" / 1000.0) AS INTEGER)`,
+			}
+			if d == Postgres {
+				return append(stmts, `ALTER TABLE "users" DROP COLUMN "unit", DROP COLUMN "plateIncrement"`)
+			}
+			return append(stmts,
+				`CREATE TABLE "users_new"(
+					"id"       INTEGER PRIMARY KEY,
+					"name"     TEXT NOT NULL,
+					"password" TEXT NOT NULL,
+					"role"     TEXT NOT NULL DEFAULT 'user'
+				)`,
+				`INSERT INTO "users_new"("id", "name", "password", "role") SELECT "id", "name", "password", "role" FROM "users"`,
+				`DROP TABLE "users"`,
+				`ALTER TABLE "users_new" RENAME TO "users"`,
+			)
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add sets.type and sets.parent",
+		Up: func(d Dialect) []string {
+			return []string{
+				`ALTER TABLE "sets" ADD COLUMN "type" TEXT NOT NULL DEFAULT 'working'`,
+				`ALTER TABLE "sets" ADD COLUMN "parent" ` + d.bigint() + ` NOT NULL DEFAULT 0 /* set ID of a drop set */`,
+				// sets done for time rather than reps were told apart by having no reps
+				`UPDATE "sets" SET "type" = 'timed'
+					WHERE "reps" = 0 AND "repsExpected" = 0 AND ("duration" > 0 OR "durationExpected" > 0)`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			if d == Postgres {
+				return []string{`ALTER TABLE "sets" DROP COLUMN "type", DROP COLUMN "parent"`}
+			}
+			// rebuilt under a new name, so the foreign key of rest_timers still names "sets"
+			const columns = `"id", "order", "reps", "weight", "duration", "rest", "repsExpected",
+				"weightExpected", "durationExpected", "restExpected", "exercise", "startedAt", "completedAt"`
+			return []string{
+				`CREATE TABLE "sets_new"(
+					"id"               INTEGER PRIMARY KEY,
+					"order"            INTEGER NOT NULL, /* first is 0, second is 1, etc. */
+					"reps"             INTEGER NOT NULL,
+					"weight"           INTEGER NOT NULL,
+					"duration"         INTEGER NOT NULL,
+					"rest"             INTEGER NOT NULL,
+					"repsExpected"     INTEGER NOT NULL,
+					"weightExpected"   INTEGER NOT NULL,
+					"durationExpected" INTEGER NOT NULL,
+					"restExpected"     INTEGER NOT NULL,
+					"exercise"         INTEGER NOT NULL,
+					"startedAt"        INTEGER NOT NULL DEFAULT 0, /* unix milliseconds */
+					"completedAt"      INTEGER NOT NULL DEFAULT 0, /* unix milliseconds */
+					FOREIGN KEY ("exercise") REFERENCES "workout_exercises"("id") ON DELETE CASCADE
+				)`,
+				`INSERT INTO "sets_new"(` + columns + `) SELECT ` + columns + ` FROM "sets"`,
+				`DROP TABLE "sets"`,
+				`ALTER TABLE "sets_new" RENAME TO "sets"`,
+				`CREATE INDEX "sets_exercise" ON "sets"("exercise", "order")`,
+			}
+		},
+	},
+	{
+		Version: 14,
+		Name:    "add sessions.previousHash and sessions.previousExpiresAt",
+		Up: func(d Dialect) []string {
+			return []string{
+				`ALTER TABLE "sessions" ADD COLUMN "previousHash" TEXT NOT NULL DEFAULT '' /* token replaced by the last rotation */`,
+				`ALTER TABLE "sessions" ADD COLUMN "previousExpiresAt" ` + d.bigint() + ` NOT NULL DEFAULT 0 /* unix time */`,
+				`CREATE INDEX "sessions_previousHash" ON "sessions"("previousHash")`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			if d == Postgres {
+				return []string{`ALTER TABLE "sessions" DROP COLUMN "previousHash", DROP COLUMN "previousExpiresAt"`}
+			}
+			const columns = `"id", "user", "tokenHash", "userAgent", "createdAt", "rotatedAt", "expiresAt"`
+			return []string{
+				`CREATE TABLE "sessions_new"(
+					"id"        INTEGER PRIMARY KEY,
+					"user"      INTEGER NOT NULL,
+					"tokenHash" TEXT NOT NULL UNIQUE,
+					"userAgent" TEXT NOT NULL,
+					"createdAt" INTEGER NOT NULL,
+					"rotatedAt" INTEGER NOT NULL,
+					"expiresAt" INTEGER NOT NULL,
+					FOREIGN KEY ("user") REFERENCES "users"("id") ON DELETE CASCADE
+				)`,
+				`INSERT INTO "sessions_new"(` + columns + `) SELECT ` + columns + ` FROM "sessions"`,
+				`DROP TABLE "sessions"`,
+				`ALTER TABLE "sessions_new" RENAME TO "sessions"`,
+				`CREATE INDEX "sessions_user" ON "sessions"("user")`,
+			}
+		},
+	},
+	{
+		Version: 15,
+		Name:    "make users.name unique",
+		Up: func(d Dialect) []string {
+			return []string{
+				// names were only checked for uniqueness before inserting, so racing
+				// sign-ups could share one: all but the first get their ID appended
+				`UPDATE "users" SET "name" = "name" || ' (' || "id" || ')'
+					WHERE "id" NOT IN (SELECT MIN("id") FROM "users" GROUP BY "name")`,
+				`CREATE UNIQUE INDEX "users_name" ON "users"("name")`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			return []string{`DROP INDEX "users_name"`}
+		},
+	},
+	{
+		Version: 16,
+		Name:    "add exercise_definitions.defaultType and exercise_definitions.defaultDuration",
+		Up: func(d Dialect) []string {
+			return []string{
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "defaultType" TEXT NOT NULL DEFAULT 'working'`,
+				`ALTER TABLE "exercise_definitions" ADD COLUMN "defaultDuration" INTEGER NOT NULL DEFAULT 0 /* milliseconds */`,
+				// the canned plank was seeded as a single rep
+				`UPDATE "exercise_definitions" SET "defaultType" = 'timed', "defaultReps" = 0, "defaultDuration" = 60000
+					WHERE "name" = 'Plank' AND "defaultReps" = 1`,
+			}
+		},
+		Down: func(d Dialect) []string {
+			stmts := []string{
+				`UPDATE "exercise_definitions" SET "defaultReps" = 1 WHERE "defaultType" = 'timed' AND "defaultReps" = 0`,
+			}
+			if d == Postgres {
+				return append(stmts, `ALTER TABLE "exercise_definitions" DROP COLUMN "defaultType", DROP COLUMN "defaultDuration"`)
+			}
+			const columns = `"id", "name", "notes", "defaultSets", "defaultReps", "defaultRest", "muscleGroups", "equipment",
+				"progression", "increment", "repsMax", "deloadAfter", "deloadPercent"`
+			return append(stmts,
+				`CREATE TABLE "exercise_definitions_new"(
+					"id"            INTEGER PRIMARY KEY,
+					"name"          TEXT NOT NULL UNIQUE,
+					"notes"         TEXT NOT NULL,
+					"defaultSets"   INTEGER NOT NULL,
+					"defaultReps"   INTEGER NOT NULL,
+					"defaultRest"   INTEGER NOT NULL,
+					"muscleGroups"  TEXT NOT NULL DEFAULT '',
+					"equipment"     TEXT NOT NULL DEFAULT '',
+					"progression"   TEXT NOT NULL DEFAULT '',
+					"increment"     INTEGER NOT NULL DEFAULT 0,
+					"repsMax"       INTEGER NOT NULL DEFAULT 0,
+					"deloadAfter"   INTEGER NOT NULL DEFAULT 0,
+					"deloadPercent" INTEGER NOT NULL DEFAULT 0
+				)`,
+				`INSERT INTO "exercise_definitions_new"(`+columns+`) SELECT `+columns+` FROM "exercise_definitions"`,
+				`DROP TABLE "exercise_definitions"`,
+				`ALTER TABLE "exercise_definitions_new" RENAME TO "exercise_definitions"`,
+			)
+		},
+	},
+}
+
+// setsReference points the foreign key of sets.exercise at the given table,
+// which must hold the IDs of every exercise that sets refer to. On SQLite,
+// sets is rebuilt with only the columns it had as of schema version 6.
+func setsReference(d Dialect, table string) []string {
+	if d == Postgres {
+		return []string{
+			`ALTER TABLE "sets" DROP CONSTRAINT "sets_exercise_fkey",
+				ADD CONSTRAINT "sets_exercise_fkey" FOREIGN KEY ("exercise") REFERENCES "` + table + `"("id") ON DELETE CASCADE`,
+		}
+	}
+	const setColumns = `"id", "order", "reps", "weight", "duration", "rest",
+		"repsExpected", "weightExpected", "durationExpected", "restExpected", "exercise"`
+	return []string{
+		`ALTER TABLE "sets" RENAME TO "sets_old"`,
+		`CREATE TABLE "sets"(
+			"id"               INTEGER PRIMARY KEY,
+			"order"            INTEGER NOT NULL, /* first is 0, second is 1, etc. */
+			"reps"             INTEGER NOT NULL,
+			"weight"           INTEGER NOT NULL,
+			"duration"         INTEGER NOT NULL,
+			"rest"             INTEGER NOT NULL,
+			"repsExpected"     INTEGER NOT NULL,
+			"weightExpected"   INTEGER NOT NULL,
+			"durationExpected" INTEGER NOT NULL,
+			"restExpected"     INTEGER NOT NULL,
+			"exercise"         INTEGER NOT NULL,
+			FOREIGN KEY ("exercise") REFERENCES "` + table + `"("id") ON DELETE CASCADE
+		)`,
+		`INSERT INTO "sets"(` + setColumns + `) SELECT ` + setColumns + ` FROM "sets_old"`,
+		`DROP TABLE "sets_old"`,
+	}
+}
+
+// resetSequence moves the Postgres ID sequence of a table past the IDs
+// inserted explicitly by a migration.
+func resetSequence(table string) string {
+	return `SELECT setval(pg_get_serial_sequence('"` + table + `"', 'id'),
+		(SELECT COALESCE(MAX("id"), 0) + 1 FROM "` + table + `"), false)`
+}
+
+// foreignKeys redefines the foreign keys of workouts, exercises, sets and
+// sessions with the given action. Postgres can swap the constraints in place.
+// SQLite can't alter constraints, so the tables are renamed out of the way,
+// recreated and refilled. The old tables are dropped children first, so the
+// implicit deletes of DROP TABLE never cascade. Rows orphaned while SQLite
+// wasn't enforcing foreign keys are not carried over.
+func foreignKeys(d Dialect, action string) []string {
+	if d == Postgres {
+		return []string{
+			`ALTER TABLE "workouts" DROP CONSTRAINT "workouts_user_fkey",
+				ADD CONSTRAINT "workouts_user_fkey" FOREIGN KEY ("user") REFERENCES "users"("id") ` + action,
+			`ALTER TABLE "exercises" DROP CONSTRAINT "exercises_workout_fkey",
+				ADD CONSTRAINT "exercises_workout_fkey" FOREIGN KEY ("workout") REFERENCES "workouts"("id") ` + action,
+			`ALTER TABLE "sets" DROP CONSTRAINT "sets_exercise_fkey",
+				ADD CONSTRAINT "sets_exercise_fkey" FOREIGN KEY ("exercise") REFERENCES "exercises"("id") ` + action,
+			`ALTER TABLE "sessions" DROP CONSTRAINT "sessions_user_fkey",
+				ADD CONSTRAINT "sessions_user_fkey" FOREIGN KEY ("user") REFERENCES "users"("id") ` + action,
+		}
+	}
+	const setColumns = `"id", "order", "reps", "weight", "duration", "rest",
+		"repsExpected", "weightExpected", "durationExpected", "restExpected", "exercise"`
+	return []string{
+		`ALTER TABLE "workouts" RENAME TO "workouts_old"`,
+		`ALTER TABLE "exercises" RENAME TO "exercises_old"`,
+		`ALTER TABLE "sets" RENAME TO "sets_old"`,
+		`ALTER TABLE "sessions" RENAME TO "sessions_old"`,
+		`DROP INDEX "sessions_user"`,
+		`CREATE TABLE "workouts"(
+			"id"        INTEGER PRIMARY KEY,
+			"name"      TEXT NOT NULL,
+			"startTime" INTEGER NOT NULL,
+			"endTime"   INTEGER NOT NULL,
+			"user"      INTEGER NOT NULL,
+			FOREIGN KEY ("user") REFERENCES "users"("id") ` + action + `
+		)`,
+		`CREATE TABLE "exercises"(
+			"id"      INTEGER PRIMARY KEY,
+			"name"    TEXT NOT NULL,
+			"notes"   TEXT NOT NULL,
+			"workout" INTEGER NOT NULL,
+			FOREIGN KEY ("workout") REFERENCES "workouts"("id") ` + action + `
+		)`,
+		`CREATE TABLE "sets"(
+			"id"               INTEGER PRIMARY KEY,
+			"order"            INTEGER NOT NULL, /* first is 0, second is 1, etc. */
+			"reps"             INTEGER NOT NULL,
+			"weight"           INTEGER NOT NULL,
+			"duration"         INTEGER NOT NULL,
+			"rest"             INTEGER NOT NULL,
+			"repsExpected"     INTEGER NOT NULL,
+			"weightExpected"   INTEGER NOT NULL,
+			"durationExpected" INTEGER NOT NULL,
+			"restExpected"     INTEGER NOT NULL,
+			"exercise"         INTEGER NOT NULL,
+			FOREIGN KEY ("exercise") REFERENCES "exercises"("id") ` + action + `
+		)`,
+		`CREATE TABLE "sessions"(
+			"id"        INTEGER PRIMARY KEY,
+			"user"      INTEGER NOT NULL,
+			"tokenHash" TEXT NOT NULL UNIQUE,
+			"userAgent" TEXT NOT NULL,
+			"createdAt" INTEGER NOT NULL,
+			"rotatedAt" INTEGER NOT NULL,
+			"expiresAt" INTEGER NOT NULL,
+			FOREIGN KEY ("user") REFERENCES "users"("id") ` + action + `
+		)`,
+		`CREATE INDEX "sessions_user" ON "sessions"("user")`,
+		`INSERT INTO "workouts" SELECT * FROM "workouts_old"
+			WHERE "user" IN (SELECT "id" FROM "users")`,
+		`INSERT INTO "exercises" SELECT * FROM "exercises_old"
+			WHERE "workout" IN (SELECT "id" FROM "workouts")`,
+		`INSERT INTO "sets"(` + setColumns + `) SELECT ` + setColumns + ` FROM "sets_old"
+			WHERE "exercise" IN (SELECT "id" FROM "exercises")`,
+		`INSERT INTO "sessions" SELECT * FROM "sessions_old"
+			WHERE "user" IN (SELECT "id" FROM "users")`,
+		`DROP TABLE "sets_old"`,
+		`DROP TABLE "exercises_old"`,
+		`DROP TABLE "workouts_old"`,
+		`DROP TABLE "sessions_old"`,
+	}
+}
+
+// Latest is the schema version reached by applying every migration.
+func Latest() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func ensureVersionTable(db sqlbuilder.Database) error {
+	_, err := db.Exec(
+		`CREATE TABLE IF NOT EXISTS "schema_version"(
+			"version"   INTEGER PRIMARY KEY,
+			"name"      TEXT NOT NULL,
+			"appliedAt" BIGINT NOT NULL
+		)`)
+	return err
+}
+
+type versionRow struct {
+	Version   int    `db:"version"`
+	Name      string `db:"name"`
+	AppliedAt int64  `db:"appliedAt"`
+}
+
+// Version returns the schema version of the database: 0 for an empty database.
+func Version(db sqlbuilder.Database) (int, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return 0, err
+	}
+	var row versionRow
+	err := db.Collection("schema_version").Find().OrderBy("-version").One(&row)
+	if err == up.ErrNoMoreRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return row.Version, nil
+}
+
+// Migrate brings the database up to the latest schema version.
+func Migrate(db sqlbuilder.Database) error {
+	return MigrateTo(db, Latest())
+}
+
+// MigrateTo applies or rolls back migrations until the database is at the
+// target version. Each step runs in its own transaction along with the
+// update of schema_version, so a failed step leaves the previous version intact.
+func MigrateTo(db sqlbuilder.Database, target int) error {
+	if target < 0 || target > Latest() {
+		return fmt.Errorf("no schema version %d (latest is %d)", target, Latest())
+	}
+	d, err := DialectOf(db)
+	if err != nil {
+		return err
+	}
+	inTx := func(fn func(tx sqlbuilder.Tx) error) error {
+		if d == SQLite {
+			return sqliteMigrationTx(db, fn)
+		}
+		return db.Tx(db.Context(), fn)
+	}
+	current, err := Version(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+		err := inTx(func(tx sqlbuilder.Tx) error {
+			if err := execAll(tx, m.Up(d)); err != nil {
+				return err
+			}
+			_, err := tx.Collection("schema_version").Insert(versionRow{
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now().Unix(),
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %s", m.Version, m.Name, err)
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		stmts := m.Down(d)
+		if len(stmts) == 0 {
+			return fmt.Errorf("migration %d (%s): %s", m.Version, m.Name, ErrIrreversible)
+		}
+		err := inTx(func(tx sqlbuilder.Tx) error {
+			if err := execAll(tx, stmts); err != nil {
+				return err
+			}
+			return tx.Collection("schema_version").Find(up.Cond{"version": m.Version}).Delete()
+		})
+		if err != nil {
+			return fmt.Errorf("rollback of migration %d (%s): %s", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// sqliteMigrationTx runs fn in a transaction on a connection with foreign
+// keys unenforced. SQLite can't rebuild a table that others reference while
+// they are enforced, and the setting can't be changed within a transaction.
+// Before committing, the foreign keys are checked as a whole.
+func sqliteMigrationTx(db sqlbuilder.Database, fn func(tx sqlbuilder.Tx) error) error {
+	sqlDB, ok := db.Driver().(*sql.DB)
+	if !ok {
+		return fmt.Errorf("unexpected SQLite driver: %T", db.Driver())
+	}
+	ctx := db.Context()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var enforced bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&enforced); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return err
+	}
+	if enforced {
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+	sqlTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	tx, err := sqlite.NewTx(sqlTx)
+	if err != nil {
+		sqlTx.Rollback()
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	rows, err := tx.Query("PRAGMA foreign_key_check")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	violated := rows.Next()
+	rows.Close()
+	if violated {
+		tx.Rollback()
+		return errors.New("foreign key constraint violated")
+	}
+	return tx.Commit()
+}
+
+func execAll(tx sqlbuilder.Tx, stmts []string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// schemaOf describes the tables of a SQLite database, their columns, foreign
+// keys and indexes, by table, to compare schemas however they were reached.
+func schemaOf(t *testing.T, db sqlbuilder.Database) map[string]string {
+	t.Helper()
+	query := func(q string) [][]string {
+		rows, err := db.Query(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		columns, err := rows.Columns()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var all [][]string
+		for rows.Next() {
+			values := make([]interface{}, len(columns))
+			for i := range values {
+				values[i] = new(interface{})
+			}
+			if err := rows.Scan(values...); err != nil {
+				t.Fatal(err)
+			}
+			row := make([]string, len(values))
+			for i, v := range values {
+				switch v := (*v.(*interface{})).(type) {
+				case []byte:
+					row[i] = string(v)
+				default:
+					row[i] = fmt.Sprint(v)
+				}
+			}
+			all = append(all, row)
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return all
+	}
+	schema := map[string]string{}
+	for _, table := range query(`SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'`) {
+		name := table[0]
+		var b strings.Builder
+		for _, column := range query(`PRAGMA table_info("` + name + `")`) {
+			// the default as written, up to a comment after it
+			if i := strings.Index(column[4], "/*"); i >= 0 {
+				column[4] = strings.TrimSpace(column[4][:i])
+			}
+			fmt.Fprintf(&b, "column %v\n", column[1:]) // all but the position
+		}
+		for _, key := range query(`PRAGMA foreign_key_list("` + name + `")`) {
+			fmt.Fprintf(&b, "foreign key %v\n", key[2:]) // all but the IDs
+		}
+		for _, index := range query(`SELECT sql FROM sqlite_master WHERE type = 'index' AND sql IS NOT NULL AND tbl_name = '` + name + `' ORDER BY name`) {
+			fmt.Fprintf(&b, "%s\n", strings.Join(strings.Fields(index[0]), " "))
+		}
+		schema[name] = b.String()
+	}
+	return schema
+}
+
+// freshDB opens an empty SQLite database. Call done when finished with it.
+func freshDB(t *testing.T) (db sqlbuilder.Database, done func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "workouttracker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = OpenSQLite(filepath.Join(dir, SQLiteFilePath))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// TestMigrateRollback rolls a database back one version at a time down to
+// an empty one, and migrates it up again. Each rollback must leave the
+// schema a fresh database has at that version, and the last one nothing but
+// the version table.
+func TestMigrateRollback(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	latest := schemaOf(t, db)
+	rolledBack := make([]map[string]string, Latest())
+	for v := Latest() - 1; v >= 0; v-- {
+		if err := MigrateTo(db, v); err != nil {
+			t.Fatalf("rolling back to %d: %s", v, err)
+		}
+		if got, err := Version(db); err != nil || got != v {
+			t.Fatalf("version %d after rolling back to %d: %v", got, v, err)
+		}
+		rolledBack[v] = schemaOf(t, db)
+	}
+	if tables := rolledBack[0]; len(tables) != 1 || tables["schema_version"] == "" {
+		t.Errorf("tables left at version 0: %v", tables)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatalf("migrating again: %s", err)
+	}
+	if got := schemaOf(t, db); !reflect.DeepEqual(got, latest) {
+		t.Errorf("schema after rolling back and migrating again differs:\ngot  %v\nwant %v", got, latest)
+	}
+
+	fresh, done := freshDB(t)
+	defer done()
+	for v := 0; v < Latest(); v++ {
+		if err := MigrateTo(fresh, v); err != nil {
+			t.Fatalf("migrating a fresh database to %d: %s", v, err)
+		}
+		want := schemaOf(t, fresh)
+		for table := range want {
+			if rolledBack[v][table] != want[table] {
+				t.Errorf("table %s rolled back to version %d differs from a fresh one:\ngot\n%swant\n%s", table, v, rolledBack[v][table], want[table])
+			}
+		}
+		for table := range rolledBack[v] {
+			if _, ok := want[table]; !ok {
+				t.Errorf("table %s is left after rolling back to version %d", table, v)
+			}
+		}
+	}
+}
+
+// TestRollbackKeepsData rolls back to the first version and migrates up
+// again with a session in progress: the records of the first version come
+// through, and the user can log in as before.
+func TestRollbackKeepsData(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	f := newFixture(t, db, "alice")
+	if err := MigrateTo(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		table string
+		cond  up.Cond
+		want  uint64
+	}{
+		{"users", up.Cond{"id": f.user.ID}, 1},
+		{"workouts", up.Cond{"user": f.user.ID}, 1},
+		{"sets", up.Cond{"exercise": f.exercise.ID}, uint64(len(f.exercise.Sets))},
+	} {
+		if n := count(t, db, c.table, c.cond); n != c.want {
+			t.Errorf("%d rows of %s after rolling back and migrating again, want %d", n, c.table, c.want)
+		}
+	}
+	if _, err := Authenticate(db, "alice", "password1"); err != nil {
+		t.Errorf("logging in after rolling back and migrating again: %s", err)
+	}
+}
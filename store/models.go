@@ -0,0 +1,97 @@
+package store
+
+type UserDB struct {
+	ID             uint64 `db:"id,omitempty" json:"id"`
+	Name           string `db:"name" json:"name"`
+	Password       string `db:"password" json:"-"`                    // bcrypt hash
+	Role           string `db:"role" json:"role"`                     // RoleUser or RoleAdmin
+	Unit           string `db:"unit" json:"unit"`                     // UnitKg or UnitLb, the unit the user sees and enters weights in
+	PlateIncrement Weight `db:"plateIncrement" json:"plateIncrement"` // in Unit, the smallest step the user's plates load; planned weights are rounded to it
+}
+
+// ExerciseDB is an exercise as performed (or planned) in one workout: an
+// instance of an entry of the exercise catalog.
+type ExerciseDB struct {
+	ID         uint64 `db:"id,omitempty" json:"id"`
+	Workout    uint64 `db:"workout" json:"workout"`
+	Definition uint64 `db:"definition" json:"definition"` // ExerciseDefinitionDB ID
+	Notes      string `db:"notes" json:"notes"`
+}
+
+type Exercise struct {
+	ExerciseDB
+	Name string  `json:"name"` // from the catalog
+	Sets []SetDB `json:"sets"`
+}
+
+type WorkoutDB struct {
+	ID           uint64 `db:"id,omitempty" json:"id"`
+	Name         string `db:"name" json:"name"`
+	StartTime    uint64 `db:"startTime" json:"startTime"`
+	StartTimeStr string `db:"-" json:"-"`
+	EndTime      uint64 `db:"endTime" json:"endTime"`
+	EndTimeStr   string `db:"-" json:"-"`
+	User         uint64 `db:"user" json:"user"`
+}
+
+// IsTemplate reports whether the workout is a template: a plan for sessions
+// that is never performed itself, so it has no start time.
+func (w WorkoutDB) IsTemplate() bool {
+	return w.StartTime == 0
+}
+
+// HasDuration reports whether the session is finished and took a known time.
+// A session imported without its duration ends as it starts.
+func (w WorkoutDB) HasDuration() bool {
+	return w.EndTime > w.StartTime
+}
+
+type Workout struct {
+	WorkoutDB
+	Exercises []Exercise `json:"exercises"`
+}
+
+type SetDB struct {
+	ID               uint64   `db:"id,omitempty" json:"id"`
+	Order            int      `db:"order" json:"order"`   // sets of an exercise have a relative order
+	Type             string   `db:"type" json:"type"`     // one of the set types, see settypes.go
+	Parent           uint64   `db:"parent" json:"parent"` // the set a drop set continues, 0 for other sets
+	Reps             int      `db:"reps" json:"reps"`
+	Weight           Weight   `db:"weight" json:"weight"`
+	Duration         int      `db:"duration" json:"duration"` // time in milliseconds of time to perform set
+	Rest             int      `db:"rest" json:"rest"`         // time in milliseconds of rest before next exercise
+	RepsExpected     int      `db:"repsExpected" json:"repsExpected"`
+	WeightExpected   Weight   `db:"weightExpected" json:"weightExpected"`
+	DurationExpected int      `db:"durationExpected" json:"durationExpected"` // time in milliseconds of time to perform set
+	RestExpected     int      `db:"restExpected" json:"restExpected"`         // time in milliseconds of rest before next exercise
+	Exercise         uint64   `db:"exercise" json:"exercise"`
+	StartedAt        int64    `db:"startedAt" json:"startedAt"`     // unix time in milliseconds, 0 until the set is started
+	CompletedAt      int64    `db:"completedAt" json:"completedAt"` // unix time in milliseconds, 0 until the set is completed
+	Records          []string `db:"-" json:"records,omitempty"`     // kinds of personal record the set holds, see records.go
+}
+
+// ExerciseDefinitionDB is an entry of the exercise catalog, independent of any workout.
+// The defaults are what a new instance of the exercise in a workout starts with.
+type ExerciseDefinitionDB struct {
+	ID           uint64 `db:"id,omitempty" json:"id"`
+	Name         string `db:"name" json:"name"`
+	Notes        string `db:"notes" json:"notes"`
+	DefaultSets  int    `db:"defaultSets" json:"defaultSets"`
+	DefaultReps  int    `db:"defaultReps" json:"defaultReps"`
+	DefaultRest  int    `db:"defaultRest" json:"defaultRest"`   // time in milliseconds of rest between sets
+	MuscleGroups string `db:"muscleGroups" json:"muscleGroups"` // comma separated, e.g. "quads,glutes"
+	Equipment    string `db:"equipment" json:"equipment"`       // e.g. "barbell", empty if none
+
+	// Sets of a new instance are of DefaultType. Timed sets expect
+	// DefaultDuration rather than DefaultReps.
+	DefaultType     string `db:"defaultType" json:"defaultType"`
+	DefaultDuration int    `db:"defaultDuration" json:"defaultDuration"` // milliseconds
+
+	// How the expected values of the exercise move from one session to the
+	// next, see progression.go.
+	Progression   string `db:"progression" json:"progression"`     // name of a progression rule, empty to repeat what was done
+	Increment     Weight `db:"increment" json:"increment"`         // weight added when progressing
+	RepsMax       int    `db:"repsMax" json:"repsMax"`             // top of the rep range of double progression, starting at DefaultReps
+	DeloadAfter   int    `db:"deloadAfter" json:"deloadAfter"`     // failed sessions in a row before a deload, 0 for never
+	DeloadPercent int    `db:"deloadPercent" json:"deloadPercent"` // weight taken off by a deload
+}
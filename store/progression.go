@@ -0,0 +1,244 @@
+package store
+
+import (
+	"sort"
+
+	up "upper.io/db.v3"
+)
+
+// Progression rules shipped with the tracker. A catalog exercise names the
+// rule its sessions follow in ExerciseDefinitionDB.Progression.
+const (
+	ProgressionLinear = "linear" // add Increment to the weight after every session done as planned
+	ProgressionDouble = "double" // add a rep after every session done as planned, up to RepsMax, then add Increment and start over from DefaultReps
+)
+
+// Performance is how the sets of an exercise went in one session.
+type Performance struct {
+	Workout uint64
+	Sets    []SetDB // by Order
+}
+
+// Met reports whether every set was done at its expected weight for at least
+// its expected reps, or for timed sets its expected duration. Sets logged
+// without expectations count as met, and sets to failure as met whatever
+// their reps.
+func (p Performance) Met() bool {
+	if len(p.Sets) == 0 {
+		return false
+	}
+	for _, s := range p.Sets {
+		if s.Weight < s.WeightExpected ||
+			s.Reps < s.RepsExpected && s.Type != SetFailure ||
+			s.Duration < s.DurationExpected && s.Type == SetTimed {
+			return false
+		}
+	}
+	return true
+}
+
+// plan is the reps and weight that were expected of the i-th set, or what
+// was done if nothing was. Sets beyond the last follow the last set. Sets
+// without a rep target plan no reps.
+func (p Performance) plan(i int) (reps int, weight Weight) {
+	if i >= len(p.Sets) {
+		i = len(p.Sets) - 1
+	}
+	s := p.Sets[i]
+	reps, weight = s.RepsExpected, s.WeightExpected
+	if reps == 0 && s.HasRepTarget() {
+		reps = s.Reps
+	}
+	if weight == 0 {
+		weight = s.Weight
+	}
+	return reps, weight
+}
+
+// A ProgressionRule plans the next session of an exercise. history is how
+// the exercise went in the user's past sessions, latest first, and is never
+// empty. planned holds the sets of the new session with the expected values
+// they would otherwise get, which the rule adjusts and returns. Warm-ups are
+// left out of both, and the reps a rule plans for a set without a rep target
+// are dropped.
+type ProgressionRule interface {
+	Plan(def ExerciseDefinitionDB, history []Performance, planned []SetDB) []SetDB
+}
+
+// ProgressionFunc adapts a function to a ProgressionRule.
+type ProgressionFunc func(def ExerciseDefinitionDB, history []Performance, planned []SetDB) []SetDB
+
+// Plan calls f.
+func (f ProgressionFunc) Plan(def ExerciseDefinitionDB, history []Performance, planned []SetDB) []SetDB {
+	return f(def, history, planned)
+}
+
+var progressions = map[string]ProgressionRule{
+	ProgressionLinear: ProgressionFunc(linearProgression),
+	ProgressionDouble: ProgressionFunc(doubleProgression),
+}
+
+// RegisterProgression makes a rule available to catalog exercises under the
+// given name, replacing any rule of that name. It is meant to be called from
+// init functions.
+func RegisterProgression(name string, rule ProgressionRule) {
+	progressions[name] = rule
+}
+
+// Progressions returns the names of the available rules, sorted.
+func Progressions() []string {
+	names := make([]string, 0, len(progressions))
+	for name := range progressions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func linearProgression(def ExerciseDefinitionDB, history []Performance, planned []SetDB) []SetDB {
+	last := history[0]
+	for i := range planned {
+		reps, weight := last.plan(i)
+		if last.Met() {
+			weight += def.Increment
+		}
+		planned[i].RepsExpected, planned[i].WeightExpected = reps, weight
+	}
+	return planned
+}
+
+func doubleProgression(def ExerciseDefinitionDB, history []Performance, planned []SetDB) []SetDB {
+	if def.RepsMax <= def.DefaultReps {
+		return linearProgression(def, history, planned)
+	}
+	last := history[0]
+	atTop := last.Met()
+	for i, s := range last.Sets {
+		if reps, _ := last.plan(i); reps < def.RepsMax && s.HasRepTarget() {
+			atTop = false
+		}
+	}
+	for i := range planned {
+		reps, weight := last.plan(i)
+		switch {
+		case atTop:
+			reps, weight = def.DefaultReps, weight+def.Increment
+		case last.Met() && reps < def.RepsMax:
+			reps++
+		}
+		planned[i].RepsExpected, planned[i].WeightExpected = reps, weight
+	}
+	return planned
+}
+
+// deload takes DeloadPercent off the planned weights once the exercise has
+// fallen short of the plan in DeloadAfter sessions in a row. It applies on
+// top of whichever rule the exercise follows.
+func deload(def ExerciseDefinitionDB, history []Performance, planned []SetDB) []SetDB {
+	failed := 0
+	for _, p := range history {
+		if p.Met() {
+			break
+		}
+		failed++
+	}
+	if def.DeloadAfter == 0 || failed < def.DeloadAfter {
+		return planned
+	}
+	for i := range planned {
+		planned[i].WeightExpected = planned[i].WeightExpected * Weight(100-def.DeloadPercent) / 100
+	}
+	return planned
+}
+
+// performances returns how the user's past sessions of a catalog exercise
+// went, latest first, leaving out warm-ups. Sessions in which none of its
+// work sets were done are left out.
+func performances(db Conn, userID, definitionID uint64) ([]Performance, error) {
+	sets, err := definitionSets(db, userID, definitionID)
+	if err != nil {
+		return nil, err
+	}
+	var history []Performance
+	index := map[uint64]int{} // workout ID -> position in history
+	for i := len(sets) - 1; i >= 0; i-- {
+		s := sets[i]
+		if s.Reps == 0 && s.Weight == 0 && s.Duration == 0 || !s.IsWork() {
+			continue
+		}
+		if _, ok := index[s.Workout]; !ok {
+			index[s.Workout] = len(history)
+			history = append(history, Performance{Workout: s.Workout})
+		}
+	}
+	for _, s := range sets {
+		if i, ok := index[s.Workout]; ok && s.IsWork() {
+			history[i].Sets = append(history[i].Sets, s.SetDB)
+		}
+	}
+	for _, p := range history {
+		sort.SliceStable(p.Sets, func(i, j int) bool { return p.Sets[i].Order < p.Sets[j].Order })
+	}
+	return history, nil
+}
+
+// progress fills in the expected values of the work sets of a new session of
+// the exercise, following the rule the catalog sets for it, with the weights
+// rounded to what the user's plates load; warm-ups are only rounded.
+// Exercises without a rule, and those never done before, keep the sets as
+// planned.
+func progress(db Conn, userID uint64, def ExerciseDefinitionDB, planned []SetDB) ([]SetDB, error) {
+	rule, ok := progressions[def.Progression]
+	if !ok && def.DeloadAfter == 0 {
+		return planned, nil
+	}
+	history, err := performances(db, userID, def.ID)
+	if err != nil || len(history) == 0 {
+		return planned, err
+	}
+	var user UserDB
+	if err := db.Collection("users").Find(userID).One(&user); err != nil {
+		return nil, err
+	}
+	var work []SetDB
+	var positions []int // of the work sets in planned
+	for i, s := range planned {
+		if s.IsWork() {
+			work = append(work, s)
+			positions = append(positions, i)
+		}
+	}
+	if ok {
+		work = rule.Plan(def, history, work)
+	}
+	work = deload(def, history, work)
+	for i, s := range work {
+		if i == len(positions) {
+			break
+		}
+		if !s.HasRepTarget() {
+			s.RepsExpected = 0
+		}
+		planned[positions[i]] = s
+	}
+	for i := range planned {
+		planned[i].WeightExpected = user.RoundWeight(planned[i].WeightExpected)
+	}
+	return planned, nil
+}
+
+// definitionsByID returns the catalog entries with the given IDs.
+func definitionsByID(db Conn, ids []uint64) (map[uint64]ExerciseDefinitionDB, error) {
+	byID := map[uint64]ExerciseDefinitionDB{}
+	if len(ids) == 0 {
+		return byID, nil
+	}
+	var definitions []ExerciseDefinitionDB
+	if err := db.Collection("exercise_definitions").Find(up.Cond{"id IN": ids}).All(&definitions); err != nil {
+		return nil, err
+	}
+	for _, d := range definitions {
+		byID[d.ID] = d
+	}
+	return byID, nil
+}
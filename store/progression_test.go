@@ -0,0 +1,173 @@
+package store
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	up "upper.io/db.v3"
+)
+
+// did is a working set done for reps at weight, of expected reps at an
+// expected weight.
+func did(reps int, weight Weight, repsExpected int, weightExpected Weight) SetDB {
+	return SetDB{Type: SetWorking, Reps: reps, Weight: weight, RepsExpected: repsExpected, WeightExpected: weightExpected}
+}
+
+// fresh returns n sets of a new session as the catalog plans them.
+func fresh(n, reps int) []SetDB {
+	sets := make([]SetDB, n)
+	for i := range sets {
+		sets[i] = SetDB{Order: i, Type: SetWorking, RepsExpected: reps}
+	}
+	return sets
+}
+
+// plans returns the expected reps and weights of the sets, for comparing.
+func plans(sets []SetDB) string {
+	s := ""
+	for _, set := range sets {
+		s += fmt.Sprintf("%d×%s ", set.RepsExpected, set.WeightExpected)
+	}
+	return s
+}
+
+func TestMet(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		sets []SetDB
+		want bool
+	}{
+		{"as planned", []SetDB{did(5, 100000, 5, 100000), did(5, 100000, 5, 100000)}, true},
+		{"more than planned", []SetDB{did(6, 102500, 5, 100000)}, true},
+		{"a rep short", []SetDB{did(5, 100000, 5, 100000), did(4, 100000, 5, 100000)}, false},
+		{"lighter", []SetDB{did(5, 97500, 5, 100000)}, false},
+		{"without a plan", []SetDB{did(3, 50000, 0, 0)}, true},
+		{"short of reps to failure", []SetDB{{Type: SetFailure, Reps: 3, Weight: 100000, RepsExpected: 5, WeightExpected: 100000}}, true},
+		{"held long enough", []SetDB{{Type: SetTimed, Duration: 60000, DurationExpected: 60000}}, true},
+		{"held too short", []SetDB{{Type: SetTimed, Duration: 45000, DurationExpected: 60000}}, false},
+		{"no sets", nil, false},
+	} {
+		if got := (Performance{Sets: c.sets}).Met(); got != c.want {
+			t.Errorf("%s: Met() = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLinearProgression(t *testing.T) {
+	def := ExerciseDefinitionDB{Progression: ProgressionLinear, DefaultReps: 5, Increment: 5 * Kilogram}
+	for _, c := range []struct {
+		name    string
+		last    []SetDB
+		planned []SetDB
+		want    string
+	}{
+		{"progress", []SetDB{did(5, 100000, 5, 100000), did(5, 100000, 5, 100000)}, fresh(2, 5), "5×105 5×105 "},
+		{"hold after a miss", []SetDB{did(5, 100000, 5, 100000), did(3, 100000, 5, 100000)}, fresh(2, 5), "5×100 5×100 "},
+		{"from what was done without a plan", []SetDB{did(8, 60000, 0, 0)}, fresh(1, 5), "8×65 "},
+		{"past failure", []SetDB{{Type: SetFailure, Reps: 2, Weight: 100000, RepsExpected: 5, WeightExpected: 100000}}, fresh(1, 5), "5×105 "},
+		{"more sets than last time", []SetDB{did(5, 80000, 5, 80000), did(5, 90000, 5, 90000)}, fresh(3, 5), "5×85 5×95 5×95 "},
+		{"no rep target", []SetDB{{Type: SetAMRAP, Reps: 12, Weight: 60000, WeightExpected: 60000}}, fresh(1, 5), "0×65 "},
+	} {
+		history := []Performance{{Sets: c.last}}
+		if got := plans(linearProgression(def, history, c.planned)); got != c.want {
+			t.Errorf("%s: planned %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDoubleProgression(t *testing.T) {
+	def := ExerciseDefinitionDB{Progression: ProgressionDouble, DefaultReps: 8, RepsMax: 12, Increment: 2500}
+	for _, c := range []struct {
+		name string
+		def  ExerciseDefinitionDB
+		last []SetDB
+		want string
+	}{
+		{"a rep more", def, []SetDB{did(8, 20000, 8, 20000), did(8, 20000, 8, 20000)}, "9×20 9×20 "},
+		{"hold after a miss", def, []SetDB{did(10, 20000, 10, 20000), did(9, 20000, 10, 20000)}, "10×20 10×20 "},
+		{"heavier at the top", def, []SetDB{did(12, 20000, 12, 20000), did(12, 20000, 12, 20000)}, "8×22.5 8×22.5 "},
+		{"not at the top until every set is", def, []SetDB{did(12, 20000, 12, 20000), did(11, 20000, 11, 20000)}, "12×20 12×20 "},
+		{"linear without a rep range", ExerciseDefinitionDB{DefaultReps: 8, RepsMax: 8, Increment: 2500},
+			[]SetDB{did(8, 20000, 8, 20000), did(8, 20000, 8, 20000)}, "8×22.5 8×22.5 "},
+	} {
+		history := []Performance{{Sets: c.last}}
+		if got := plans(doubleProgression(c.def, history, fresh(2, 8))); got != c.want {
+			t.Errorf("%s: planned %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDeload(t *testing.T) {
+	def := ExerciseDefinitionDB{Progression: ProgressionLinear, DeloadAfter: 3, DeloadPercent: 10}
+	met := Performance{Sets: []SetDB{did(5, 100000, 5, 100000)}}
+	missed := Performance{Sets: []SetDB{did(4, 100000, 5, 100000)}}
+	for _, c := range []struct {
+		name    string
+		def     ExerciseDefinitionDB
+		history []Performance // latest first
+		want    string
+	}{
+		{"after three misses", def, []Performance{missed, missed, missed}, "5×90 "},
+		{"after more", def, []Performance{missed, missed, missed, missed, met}, "5×90 "},
+		{"after two", def, []Performance{missed, missed, met, missed}, "5×100 "},
+		{"after a session done", def, []Performance{met, missed, missed, missed}, "5×100 "},
+		{"never", ExerciseDefinitionDB{Progression: ProgressionLinear, DeloadPercent: 10}, []Performance{missed, missed, missed, missed}, "5×100 "},
+	} {
+		planned := []SetDB{{Type: SetWorking, RepsExpected: 5, WeightExpected: 100000}}
+		if got := plans(deload(c.def, c.history, planned)); got != c.want {
+			t.Errorf("%s: planned %s, want %s", c.name, got, c.want)
+		}
+	}
+}
+
+// TestProgressRounding plans a session after one done as planned: the
+// weights progress, and are rounded to what the user's plates load.
+func TestProgressRounding(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	user, err := CreateUser(db, "alice", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Collection("users").Find(user.ID).Update(up.Cond{"unit": UnitLb, "plateIncrement": 5000}); err != nil {
+		t.Fatal(err)
+	}
+	def := ExerciseDefinitionDB{Name: "Squat", DefaultSets: 2, DefaultReps: 5, DefaultType: SetWorking,
+		Progression: ProgressionLinear, Increment: 2500}
+	if err := db.Collection("exercise_definitions").InsertReturning(&def); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, 2, 1, 18, 0, 0, 0, time.UTC).Unix()
+	workout := WorkoutDB{Name: "Monday", StartTime: uint64(start), EndTime: uint64(start + 3600), User: user.ID}
+	if err := db.Collection("workouts").InsertReturning(&workout); err != nil {
+		t.Fatal(err)
+	}
+	exercise := ExerciseDB{Workout: workout.ID, Definition: def.ID}
+	if err := db.Collection("workout_exercises").InsertReturning(&exercise); err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range []SetDB{
+		{Type: SetWarmup, Reps: 5, Weight: 60000, RepsExpected: 5, WeightExpected: 60000},
+		did(5, 100000, 5, 100000),
+		did(5, 100000, 5, 100000),
+	} {
+		s.Order, s.Exercise = i, exercise.ID
+		if err := db.Collection("sets").InsertReturning(&s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	planned := append([]SetDB{{Type: SetWarmup, RepsExpected: 5, WeightExpected: 40000}}, fresh(2, 5)...)
+	planned, err = progress(db, user.ID, def, planned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 102.5 kg is 225.97 lb, loaded as 225 lb; the warm-up is only rounded,
+	// from 88.18 lb
+	for i, want := range []Weight{90000, 225000, 225000} {
+		if got := planned[i].WeightExpected.ToUnit(UnitLb); got != want {
+			t.Errorf("set %d planned at %s lb, want %s lb", i+1, got, want)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"math"
+	"sort"
+
+	up "upper.io/db.v3"
+)
+
+// Kinds of personal records. Records are per user and catalog exercise, and
+// only sets of sessions count.
+const (
+	RecordWeight   = "weight"   // heaviest weight lifted for at least one rep
+	RecordReps     = "reps"     // most reps at a given weight
+	RecordEpley    = "epley"    // best one-rep max estimated with Epley's formula
+	RecordBrzycki  = "brzycki"  // best one-rep max estimated with Brzycki's formula
+	RecordDuration = "duration" // longest set of an exercise done for time rather than reps
+)
+
+// Record is a personal record set by a set: it beat every work set of the
+// exercise the user logged before it. Records aren't stored but worked out
+// from the sets, so they follow any edit or removal of a set.
+type Record struct {
+	Definition uint64  `json:"definition"` // the catalog exercise
+	Set        uint64  `json:"set"`
+	Workout    uint64  `json:"workout"`
+	Kind       string  `json:"kind"`
+	Weight     Weight  `json:"weight"`     // the weight of a reps record, else 0
+	Value      float64 `json:"value"`      // weight in units, reps, estimated weight in units or milliseconds, by kind
+	AchievedAt int64   `json:"achievedAt"` // unix time in milliseconds
+}
+
+// Epley estimates the one-rep max from a set of reps at a weight, in the
+// units of the weight.
+func Epley(weight Weight, reps int) float64 {
+	if reps <= 1 {
+		return weight.Float()
+	}
+	return round2(weight.Float() * (1 + float64(reps)/30))
+}
+
+// Brzycki estimates the one-rep max from a set of reps at a weight. The
+// formula breaks down at 37 reps and beyond, for which it returns 0.
+func Brzycki(weight Weight, reps int) float64 {
+	if reps >= 37 {
+		return 0
+	}
+	return round2(weight.Float() * 36 / float64(37-reps))
+}
+
+func round2(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// candidates lists the records the set would set if it beat the previous
+// bests. Warm-ups set none.
+func candidates(set SetDB) []Record {
+	var records []Record
+	if !set.IsWork() {
+		return records
+	}
+	add := func(kind string, weight Weight, value float64) {
+		if value > 0 {
+			records = append(records, Record{Set: set.ID, Kind: kind, Weight: weight, Value: value})
+		}
+	}
+	switch {
+	case set.Reps > 0:
+		add(RecordWeight, 0, set.Weight.Float())
+		add(RecordReps, set.Weight, float64(set.Reps))
+		add(RecordEpley, 0, Epley(set.Weight, set.Reps))
+		add(RecordBrzycki, 0, Brzycki(set.Weight, set.Reps))
+	case set.Duration > 0:
+		add(RecordDuration, 0, float64(set.Duration))
+	}
+	return records
+}
+
+// loggedSet is a set of a session along with when it was done.
+type loggedSet struct {
+	SetDB     `db:",inline"`
+	Workout   uint64 `db:"workout"`
+	StartTime uint64 `db:"startTime"` // of the session, unix seconds
+	At        int64  `db:"-"`         // unix time in milliseconds
+}
+
+// definitionSets returns every set the user has logged in sessions of a
+// catalog exercise, in the order they were done. Sets completed live are
+// placed by their completion, others by the start of their session. It is
+// the one place sets are read across workouts, so it joins the tables on
+// the indexes of schema version 10 rather than reading them one by one.
+func definitionSets(db Conn, userID, definitionID uint64) ([]loggedSet, error) {
+	var logged []loggedSet
+	err := db.Select("s.*", "e.workout", "w.startTime").
+		From("sets AS s").
+		Join("workout_exercises AS e").On("e.id = s.exercise").
+		Join("workouts AS w").On("w.id = e.workout").
+		Where(up.Cond{"e.definition": definitionID, "w.user": userID, "w.startTime >": 0}).
+		All(&logged)
+	if err != nil {
+		return nil, err
+	}
+	for i := range logged {
+		s := &logged[i]
+		s.At = s.CompletedAt
+		if s.At == 0 { // logged after the fact
+			s.At = int64(s.StartTime) * 1000
+		}
+	}
+	sort.Slice(logged, func(i, j int) bool {
+		a, b := logged[i], logged[j]
+		if a.At != b.At {
+			return a.At < b.At
+		}
+		if a.Exercise != b.Exercise {
+			return a.Exercise < b.Exercise
+		}
+		return a.Order < b.Order
+	})
+	return logged, nil
+}
+
+// Records returns the history of the user's records for a catalog exercise,
+// oldest first. The last record of a kind is the current one.
+func Records(db Conn, userID, definitionID uint64) ([]Record, error) {
+	exists, err := db.Collection("exercise_definitions").Find(definitionID).Exists()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNotFound
+	}
+	sets, err := definitionSets(db, userID, definitionID)
+	if err != nil {
+		return nil, err
+	}
+	return records(definitionID, sets), nil
+}
+
+// records works out the records set by the sets of a catalog exercise, given
+// in the order they were done.
+func records(definitionID uint64, sets []loggedSet) []Record {
+	type key struct {
+		kind   string
+		weight Weight
+	}
+	bests := map[key]float64{}
+	all := []Record{}
+	for _, s := range sets {
+		for _, r := range candidates(s.SetDB) {
+			k := key{r.Kind, r.Weight}
+			if r.Value <= bests[k] {
+				continue
+			}
+			bests[k] = r.Value
+			r.Definition = definitionID
+			r.Workout = s.Workout
+			r.AchievedAt = s.At
+			all = append(all, r)
+		}
+	}
+	return all
+}
+
+// setRecords returns the kinds of record held by each set of the user's
+// sessions of a catalog exercise, by set ID.
+func setRecords(db Conn, userID, definitionID uint64) (map[uint64][]string, error) {
+	all, err := Records(db, userID, definitionID)
+	if err != nil {
+		return nil, err
+	}
+	return recordKinds(all), nil
+}
+
+// recordKinds returns the kinds of record held by each set, by set ID.
+func recordKinds(records []Record) map[uint64][]string {
+	bySet := map[uint64][]string{}
+	for _, r := range records {
+		bySet[r.Set] = append(bySet[r.Set], r.Kind)
+	}
+	return bySet
+}
+
+// withRecords fills in the records held by a set just saved. Sets of
+// templates hold none.
+func withRecords(db Conn, workout WorkoutDB, exercise ExerciseDB, set SetDB) (SetDB, error) {
+	if workout.IsTemplate() {
+		return set, nil
+	}
+	bySet, err := setRecords(db, workout.User, exercise.Definition)
+	if err != nil {
+		return SetDB{}, err
+	}
+	set.Records = bySet[set.ID]
+	return set, nil
+}
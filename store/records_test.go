@@ -0,0 +1,129 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOneRepMax(t *testing.T) {
+	for _, c := range []struct {
+		weight         Weight
+		reps           int
+		epley, brzycki float64
+	}{
+		{100000, 1, 100, 100},
+		{100000, 5, 116.67, 112.5},
+		{60000, 10, 80, 80},
+		{102500, 3, 112.75, 108.53},
+		{50000, 36, 110, 1800},
+		{50000, 37, 111.67, 0}, // past what Brzycki's formula covers
+	} {
+		if got := Epley(c.weight, c.reps); got != c.epley {
+			t.Errorf("Epley(%s, %d) = %v, want %v", c.weight, c.reps, got, c.epley)
+		}
+		if got := Brzycki(c.weight, c.reps); got != c.brzycki {
+			t.Errorf("Brzycki(%s, %d) = %v, want %v", c.weight, c.reps, got, c.brzycki)
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	set := func(id uint64, typ string, reps int, weight Weight, duration int) loggedSet {
+		return loggedSet{SetDB: SetDB{ID: id, Type: typ, Reps: reps, Weight: weight, Duration: duration}, Workout: 1, At: int64(id)}
+	}
+	sets := []loggedSet{
+		set(1, SetWarmup, 5, 120000, 0), // heavier, but a warm-up
+		set(2, SetWorking, 5, 100000, 0),
+		set(3, SetWorking, 3, 100000, 0), // no better than set 2
+		set(4, SetWorking, 8, 90000, 0),  // the most reps at its weight only
+		set(5, SetDrop, 6, 100000, 0),
+		set(6, SetTimed, 0, 0, 45000),
+		set(7, SetTimed, 0, 0, 30000),
+	}
+	type record struct {
+		set    uint64
+		kind   string
+		weight Weight
+		value  float64
+	}
+	want := []record{
+		{2, RecordWeight, 0, 100},
+		{2, RecordReps, 100000, 5},
+		{2, RecordEpley, 0, 116.67},
+		{2, RecordBrzycki, 0, 112.5},
+		{4, RecordReps, 90000, 8},
+		{5, RecordReps, 100000, 6},
+		{5, RecordEpley, 0, 120},
+		{5, RecordBrzycki, 0, 116.13},
+		{6, RecordDuration, 0, 45000},
+	}
+	got := records(42, sets)
+	if len(got) != len(want) {
+		t.Fatalf("%d records, want %d: %+v", len(got), len(want), got)
+	}
+	for i, r := range got {
+		if (record{r.Set, r.Kind, r.Weight, r.Value}) != want[i] || r.Definition != 42 || r.AchievedAt != int64(r.Set) {
+			t.Errorf("record %d is %+v, want %+v", i+1, r, want[i])
+		}
+	}
+	if kinds := recordKinds(got)[1]; len(kinds) != 0 {
+		t.Errorf("the warm-up holds records %q", kinds)
+	}
+}
+
+// TestRecordsOrder logs a set after the fact in a session that started
+// before another set was completed live: it was done first, so it holds the
+// record the other only equals.
+func TestRecordsOrder(t *testing.T) {
+	db, done := testDB(t)
+	defer done()
+	user, err := CreateUser(db, "alice", "password1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := ExerciseDefinitionDB{Name: "Squat", DefaultSets: 1, DefaultReps: 5, DefaultType: SetWorking}
+	if err := db.Collection("exercise_definitions").InsertReturning(&def); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2019, 2, 1, 18, 0, 0, 0, time.UTC)
+	// the session logged live started first, but its set was completed last
+	var setIDs []uint64
+	for _, s := range []struct {
+		start       time.Time
+		completedAt time.Time // zero if logged after the fact
+	}{
+		{start, start.Add(30 * time.Minute)},
+		{start.Add(10 * time.Minute), time.Time{}},
+	} {
+		workout := WorkoutDB{Name: "Squats", StartTime: uint64(s.start.Unix()), EndTime: uint64(s.start.Add(time.Hour).Unix()), User: user.ID}
+		if err := db.Collection("workouts").InsertReturning(&workout); err != nil {
+			t.Fatal(err)
+		}
+		exercise := ExerciseDB{Workout: workout.ID, Definition: def.ID}
+		if err := db.Collection("workout_exercises").InsertReturning(&exercise); err != nil {
+			t.Fatal(err)
+		}
+		set := SetDB{Type: SetWorking, Reps: 5, Weight: 100000, Exercise: exercise.ID}
+		if !s.completedAt.IsZero() {
+			set.StartedAt = s.completedAt.Add(-time.Minute).UnixNano() / 1e6
+			set.CompletedAt = s.completedAt.UnixNano() / 1e6
+		}
+		if err := db.Collection("sets").InsertReturning(&set); err != nil {
+			t.Fatal(err)
+		}
+		setIDs = append(setIDs, set.ID)
+	}
+
+	all, err := Records(db, user.ID, def.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := recordKinds(all)
+	if len(kinds[setIDs[1]]) != 4 || len(kinds[setIDs[0]]) != 0 {
+		t.Errorf("set logged after the fact holds %q and the one completed live %q, want all four and none",
+			kinds[setIDs[1]], kinds[setIDs[0]])
+	}
+	if len(all) > 0 && all[0].AchievedAt != start.Add(10*time.Minute).Unix()*1000 {
+		t.Errorf("record achieved at %d, want the start of its session", all[0].AchievedAt)
+	}
+}
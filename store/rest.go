@@ -0,0 +1,148 @@
+package store
+
+import (
+	"errors"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// ErrNoRestTimer is returned when a session has no rest timer running.
+var ErrNoRestTimer = errors.New("no rest timer is running")
+
+// RestTimerDB is the countdown of the rest after a completed set. A session
+// has at most one: completing a set replaces it. The timer ends when the
+// next set is started or the rest is skipped, and the rest actually taken is
+// then written to the set's Rest. Overrunning the countdown doesn't end it.
+type RestTimerDB struct {
+	Workout   uint64 `db:"workout" json:"workout"`
+	Set       uint64 `db:"set" json:"set"`             // the set the rest follows
+	StartedAt int64  `db:"startedAt" json:"startedAt"` // unix time in milliseconds
+	Duration  int64  `db:"duration" json:"duration"`   // planned rest in milliseconds, starting from the set's RestExpected
+	EndedAt   int64  `db:"endedAt" json:"endedAt"`     // unix time in milliseconds, 0 while running
+	Remaining int64  `db:"-" json:"remaining"`         // milliseconds left when the timer was read, negative once overrun
+}
+
+// Running reports whether the rest is still going on.
+func (t RestTimerDB) Running() bool {
+	return t.EndedAt == 0
+}
+
+// EndsAt is the unix time in milliseconds the countdown reaches zero.
+func (t RestTimerDB) EndsAt() int64 {
+	return t.StartedAt + t.Duration
+}
+
+func (t RestTimerDB) at(now int64) RestTimerDB {
+	if t.Running() {
+		t.Remaining = t.EndsAt() - now
+	}
+	return t
+}
+
+func restTimer(db Conn, workoutID uint64) (RestTimerDB, error) {
+	var timer RestTimerDB
+	err := db.Collection("rest_timers").Find(up.Cond{"workout": workoutID}).One(&timer)
+	if err == up.ErrNoMoreRows {
+		return RestTimerDB{}, ErrNoRestTimer
+	}
+	return timer, err
+}
+
+// startRest starts the countdown after the set, replacing the session's previous timer.
+func startRest(db Conn, workoutID uint64, set SetDB) error {
+	timers := db.Collection("rest_timers")
+	if err := timers.Find(up.Cond{"workout": workoutID}).Delete(); err != nil {
+		return err
+	}
+	_, err := timers.Insert(RestTimerDB{
+		Workout:   workoutID,
+		Set:       set.ID,
+		StartedAt: set.CompletedAt,
+		Duration:  int64(set.RestExpected),
+	})
+	return err
+}
+
+// endRest ends the session's running timer, if any, and records the rest
+// taken in the set before it. ok is false if no timer was running.
+func endRest(db Conn, workoutID uint64, now int64) (timer RestTimerDB, ok bool, err error) {
+	timer, err = restTimer(db, workoutID)
+	if err == ErrNoRestTimer || (err == nil && !timer.Running()) {
+		return RestTimerDB{}, false, nil
+	}
+	if err != nil {
+		return RestTimerDB{}, false, err
+	}
+	timer.EndedAt = now
+	err = db.Collection("rest_timers").Find(up.Cond{"workout": workoutID}).Update(timer)
+	if err != nil {
+		return RestTimerDB{}, false, err
+	}
+	rest := up.Cond{"rest": int(now - timer.StartedAt)}
+	if err := db.Collection("sets").Find(timer.Set).Update(rest); err != nil {
+		return RestTimerDB{}, false, err
+	}
+	return timer, true, nil
+}
+
+// RestTimer returns the latest rest timer of one of the user's sessions,
+// running or not.
+func RestTimer(db Conn, userID, workoutID uint64) (RestTimerDB, error) {
+	if _, err := UserWorkout(db, userID, workoutID); err != nil {
+		return RestTimerDB{}, err
+	}
+	timer, err := restTimer(db, workoutID)
+	if err != nil {
+		return RestTimerDB{}, err
+	}
+	return timer.at(nowMillis()), nil
+}
+
+// ExtendRest adds to the planned rest of the running timer. A negative
+// amount shortens it, down to no rest at all.
+func ExtendRest(db sqlbuilder.Database, userID, workoutID uint64, by int64) (RestTimerDB, error) {
+	var timer RestTimerDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		if _, err := liveWorkout(tx, userID, workoutID); err != nil {
+			return err
+		}
+		var err error
+		timer, err = restTimer(tx, workoutID)
+		if err == nil && !timer.Running() {
+			err = ErrNoRestTimer
+		}
+		if err != nil {
+			return err
+		}
+		timer.Duration += by
+		if timer.Duration < 0 {
+			timer.Duration = 0
+		}
+		return tx.Collection("rest_timers").Find(up.Cond{"workout": workoutID}).Update(timer)
+	})
+	if err != nil {
+		return RestTimerDB{}, err
+	}
+	return timer.at(nowMillis()), nil
+}
+
+// SkipRest ends the running timer now, as when the next set is started.
+func SkipRest(db sqlbuilder.Database, userID, workoutID uint64) (RestTimerDB, error) {
+	var timer RestTimerDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		if _, err := liveWorkout(tx, userID, workoutID); err != nil {
+			return err
+		}
+		var ok bool
+		var err error
+		if timer, ok, err = endRest(tx, workoutID, nowMillis()); err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNoRestTimer
+		}
+		return nil
+	})
+	return timer, err
+}
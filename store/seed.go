@@ -0,0 +1,112 @@
+package store
+
+import (
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+const (
+	minute = 60 * 1000 // milliseconds
+)
+
+// CannedExercises is the catalog a fresh database is seeded with. Most
+// exercises default to three sets, per the original design notes. The main
+// barbell lifts progress linearly, deloading after three failed sessions,
+// and the accessories by double progression.
+var CannedExercises = []ExerciseDefinitionDB{
+	{Name: "Squat", Notes: "Barbell back squat.", DefaultSets: 3, DefaultReps: 5, DefaultRest: 3 * minute, MuscleGroups: "quads,glutes", Equipment: "barbell", Progression: ProgressionLinear, Increment: 5 * Kilogram, DeloadAfter: 3, DeloadPercent: 10},
+	{Name: "Front Squat", Notes: "Barbell front squat.", DefaultSets: 3, DefaultReps: 5, DefaultRest: 3 * minute, MuscleGroups: "quads,glutes", Equipment: "barbell", Progression: ProgressionLinear, Increment: 5 * Kilogram, DeloadAfter: 3, DeloadPercent: 10},
+	{Name: "Deadlift", Notes: "Conventional barbell deadlift.", DefaultSets: 1, DefaultReps: 5, DefaultRest: 3 * minute, MuscleGroups: "hamstrings,glutes,back", Equipment: "barbell", Progression: ProgressionLinear, Increment: 10 * Kilogram, DeloadAfter: 3, DeloadPercent: 10},
+	{Name: "Romanian Deadlift", Notes: "", DefaultSets: 3, DefaultReps: 8, DefaultRest: 2 * minute, MuscleGroups: "hamstrings,glutes", Equipment: "barbell", Progression: ProgressionDouble, Increment: 10 * Kilogram, RepsMax: 12},
+	{Name: "Bench Press", Notes: "Barbell flat bench press.", DefaultSets: 3, DefaultReps: 5, DefaultRest: 3 * minute, MuscleGroups: "chest,triceps,shoulders", Equipment: "barbell", Progression: ProgressionLinear, Increment: 5 * Kilogram, DeloadAfter: 3, DeloadPercent: 10},
+	{Name: "Incline Bench Press", Notes: "", DefaultSets: 3, DefaultReps: 8, DefaultRest: 2 * minute, MuscleGroups: "chest,shoulders,triceps", Equipment: "barbell", Progression: ProgressionDouble, Increment: 5 * Kilogram, RepsMax: 12},
+	{Name: "Overhead Press", Notes: "Standing barbell press.", DefaultSets: 3, DefaultReps: 5, DefaultRest: 3 * minute, MuscleGroups: "shoulders,triceps", Equipment: "barbell", Progression: ProgressionLinear, Increment: 5 * Kilogram, DeloadAfter: 3, DeloadPercent: 10},
+	{Name: "Barbell Row", Notes: "Bent-over row.", DefaultSets: 3, DefaultReps: 5, DefaultRest: 2 * minute, MuscleGroups: "back,biceps", Equipment: "barbell", Progression: ProgressionLinear, Increment: 5 * Kilogram, DeloadAfter: 3, DeloadPercent: 10},
+	{Name: "Pull Up", Notes: "", DefaultSets: 3, DefaultReps: 8, DefaultRest: 2 * minute, MuscleGroups: "back,biceps", Equipment: "pull-up bar"},
+	{Name: "Chin Up", Notes: "", DefaultSets: 3, DefaultReps: 8, DefaultRest: 2 * minute, MuscleGroups: "back,biceps", Equipment: "pull-up bar"},
+	{Name: "Dip", Notes: "", DefaultSets: 3, DefaultReps: 10, DefaultRest: 90 * 1000, MuscleGroups: "chest,triceps", Equipment: "dip bars"},
+	{Name: "Lunge", Notes: "Reps are per leg.", DefaultSets: 3, DefaultReps: 10, DefaultRest: 90 * 1000, MuscleGroups: "quads,glutes", Equipment: "dumbbells"},
+	{Name: "Bicep Curl", Notes: "", DefaultSets: 3, DefaultReps: 12, DefaultRest: minute, MuscleGroups: "biceps", Equipment: "dumbbells", Progression: ProgressionDouble, Increment: 5 * Kilogram, RepsMax: 15},
+	{Name: "Tricep Extension", Notes: "", DefaultSets: 3, DefaultReps: 12, DefaultRest: minute, MuscleGroups: "triceps", Equipment: "cable", Progression: ProgressionDouble, Increment: 5 * Kilogram, RepsMax: 15},
+	{Name: "Lateral Raise", Notes: "", DefaultSets: 3, DefaultReps: 15, DefaultRest: minute, MuscleGroups: "shoulders", Equipment: "dumbbells", Progression: ProgressionDouble, Increment: 5 * Kilogram, RepsMax: 20},
+	{Name: "Calf Raise", Notes: "", DefaultSets: 3, DefaultReps: 15, DefaultRest: minute, MuscleGroups: "calves", Equipment: ""},
+	{Name: "Plank", Notes: "", DefaultSets: 3, DefaultType: SetTimed, DefaultDuration: minute, DefaultRest: minute, MuscleGroups: "core", Equipment: ""},
+}
+
+// CannedAliases are other names of canned exercises, mostly as other apps
+// name them, by the canned name. Names that only add the equipment in
+// parentheses, like "Squat (Barbell)", match without an alias.
+var CannedAliases = map[string][]string{
+	"Squat":               {"Back Squat", "Barbell Squat", "Barbell Back Squat", "High Bar Squat", "Low Bar Squat"},
+	"Front Squat":         {"Barbell Front Squat"},
+	"Deadlift":            {"Conventional Deadlift", "Barbell Deadlift"},
+	"Romanian Deadlift":   {"RDL", "Barbell Romanian Deadlift", "Stiff-Legged Deadlift"},
+	"Bench Press":         {"Flat Barbell Bench Press", "Barbell Bench Press", "Flat Bench Press"},
+	"Incline Bench Press": {"Incline Barbell Bench Press"},
+	"Overhead Press":      {"OHP", "Military Press", "Strict Press", "Standing Press", "Overhead Barbell Press", "Shoulder Press"},
+	"Barbell Row":         {"Bent Over Row", "Bent Over Barbell Row", "Pendlay Row"},
+	"Pull Up":             {"Pullup", "Pull Ups"},
+	"Chin Up":             {"Chinup", "Chin Ups"},
+	"Dip":                 {"Dips", "Chest Dip", "Triceps Dip", "Parallel Bar Dip"},
+	"Lunge":               {"Lunges", "Dumbbell Lunge", "Walking Lunge"},
+	"Bicep Curl":          {"Biceps Curl", "Dumbbell Curl", "Dumbbell Bicep Curl"},
+	"Tricep Extension":    {"Triceps Extension", "Triceps Pushdown", "Tricep Pushdown", "Cable Tricep Extension"},
+	"Lateral Raise":       {"Side Lateral Raise", "Dumbbell Lateral Raise"},
+	"Calf Raise":          {"Standing Calf Raise", "Seated Calf Raise"},
+}
+
+// SeedExercises adds the canned exercises missing from the catalog, and the
+// canned aliases of the catalog's exercises, and returns how many exercises
+// were added. Existing entries of the same name, and existing aliases, are
+// left alone.
+func SeedExercises(db sqlbuilder.Database) (int, error) {
+	added := 0
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		catalog := tx.Collection("exercise_definitions")
+		for _, e := range CannedExercises {
+			exists, err := catalog.Find(up.Cond{"name": e.Name}).Exists()
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if e.DefaultType == "" {
+				e.DefaultType = SetWorking
+			}
+			if _, err := catalog.Insert(e); err != nil {
+				return err
+			}
+			added++
+		}
+		aliases := tx.Collection("exercise_aliases")
+		for name, others := range CannedAliases {
+			var def ExerciseDefinitionDB
+			err := catalog.Find(up.Cond{"name": name}).One(&def)
+			if err == up.ErrNoMoreRows { // renamed or removed by an admin
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			for _, alias := range others {
+				a := ExerciseAliasDB{Alias: normalizeName(alias), Definition: def.ID}
+				exists, err := aliases.Find(up.Cond{"alias": a.Alias}).Exists()
+				if err != nil {
+					return err
+				}
+				if exists {
+					continue
+				}
+				if _, err := aliases.Insert(a); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return added, nil
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"errors"
+
+	up "upper.io/db.v3"
+)
+
+// Types of sets. Warm-ups are left out of volume, records and progression.
+// Sets of the other types are work, and all but AMRAP and timed sets aim at
+// RepsExpected.
+const (
+	SetWorking = "working" // the default
+	SetWarmup  = "warmup"
+	SetDrop    = "drop"    // done straight after its Parent at a lower weight
+	SetAMRAP   = "amrap"   // as many reps as possible: no rep target
+	SetFailure = "failure" // taken to failure, so falling short of its reps doesn't hold back progression
+	SetTimed   = "timed"   // held for time, like planks and carries: aims at DurationExpected rather than reps
+)
+
+var (
+	ErrBadSetType  = errors.New("set type must be working, warmup, drop, amrap, failure or timed")
+	ErrRepTarget   = errors.New("AMRAP and timed sets have no expected reps")
+	ErrDropParent  = errors.New("a drop set needs a parent set of the same exercise that is neither a warm-up nor a drop set, and other sets have none")
+	ErrHasDropSets = errors.New("the set has drop sets, so it must stay work and can't be removed before them")
+)
+
+// SetTypes are the types of sets, in the order to offer them.
+var SetTypes = []string{SetWorking, SetWarmup, SetDrop, SetAMRAP, SetFailure, SetTimed}
+
+// IsWork reports whether the set counts toward volume, records and
+// progression, as every set but a warm-up does.
+func (s SetDB) IsWork() bool {
+	return s.Type != SetWarmup
+}
+
+// HasRepTarget reports whether the set aims at a number of reps.
+func (s SetDB) HasRepTarget() bool {
+	return s.Type != SetAMRAP && s.Type != SetTimed
+}
+
+// validSetType checks the type of a set about to be saved, and the link of a
+// drop set to its parent.
+func validSetType(db Conn, s SetDB) error {
+	valid := false
+	for _, t := range SetTypes {
+		valid = valid || s.Type == t
+	}
+	if !valid {
+		return ErrBadSetType
+	}
+	if !s.HasRepTarget() && s.RepsExpected != 0 {
+		return ErrRepTarget
+	}
+	if s.Type != SetDrop && s.Parent != 0 {
+		return ErrDropParent
+	}
+	if s.Type == SetDrop {
+		var parent SetDB
+		err := db.Collection("sets").Find(s.Parent).One(&parent)
+		if err == up.ErrNoMoreRows || err == nil && (parent.Exercise != s.Exercise || !parent.IsWork() || parent.Type == SetDrop) {
+			return ErrDropParent
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if s.ID != 0 && (s.Type == SetWarmup || s.Type == SetDrop) {
+		hasDrops, err := db.Collection("sets").Find(up.Cond{"parent": s.ID}).Exists()
+		if err != nil {
+			return err
+		}
+		if hasDrops {
+			return ErrHasDropSets
+		}
+	}
+	return nil
+}
+
+// linkDropSets points the drop sets just inserted at the copies of their
+// parents, given the IDs of the copies by the IDs of the originals.
+func linkDropSets(db Conn, sets []SetDB, copies map[uint64]uint64) error {
+	for _, s := range sets {
+		if s.Parent == 0 {
+			continue
+		}
+		err := db.Collection("sets").Find(copies[s.ID]).Update(up.Cond{"parent": copies[s.Parent]})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
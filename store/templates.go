@@ -0,0 +1,60 @@
+package store
+
+import (
+	"errors"
+	"strings"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+// ErrFinishTemplate is returned when setting the end time of a template.
+// Templates are plans only and can't be performed or finished.
+var ErrFinishTemplate = errors.New("a template cannot be finished")
+
+// UserSessions returns the user's performed or in-progress workouts, oldest first.
+func UserSessions(db Conn, userID uint64) ([]WorkoutDB, error) {
+	workouts := []WorkoutDB{}
+	err := db.Collection("workouts").Find(up.Cond{"user": userID, "startTime >": 0}).OrderBy("startTime").All(&workouts)
+	return workouts, err
+}
+
+// UserTemplates returns the user's templates by name.
+func UserTemplates(db Conn, userID uint64) ([]WorkoutDB, error) {
+	templates := []WorkoutDB{}
+	err := db.Collection("workouts").Find(up.Cond{"user": userID, "startTime": 0}).OrderBy("name").All(&templates)
+	return templates, err
+}
+
+// CreateTemplate adds an empty template for the user.
+func CreateTemplate(db Conn, userID uint64, name string) (WorkoutDB, error) {
+	template := WorkoutDB{
+		Name: strings.TrimSpace(name),
+		User: userID,
+	}
+	if template.Name == "" {
+		template.Name = "new template"
+	}
+	err := db.Collection("workouts").InsertReturning(&template)
+	return template, err
+}
+
+// SaveAsTemplate adds a template planned after one of the user's workouts,
+// with what was actually done in each set as the expected values.
+func SaveAsTemplate(db sqlbuilder.Database, userID, workoutID uint64) (WorkoutDB, error) {
+	return copyWorkout(db, userID, workoutID, 0)
+}
+
+// RenameWorkout changes the name of one of the user's unfinished workouts or templates.
+func RenameWorkout(db Conn, userID, workoutID uint64, name string) (WorkoutDB, error) {
+	workout, err := editableWorkout(db, userID, workoutID)
+	if err != nil {
+		return WorkoutDB{}, err
+	}
+	workout.Name = strings.TrimSpace(name)
+	if err := validWorkout(workout); err != nil {
+		return WorkoutDB{}, err
+	}
+	err = db.Collection("workouts").Find(workoutID).Update(up.Cond{"name": workout.Name})
+	return workout, err
+}
@@ -0,0 +1,270 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	up "upper.io/db.v3"
+)
+
+// Weight units. The database holds weights in kilograms; each user sees and
+// enters them in the unit of their preference, UserDB.Unit.
+const (
+	UnitKg = "kg"
+	UnitLb = "lb"
+)
+
+const kgPerLb = 0.45359237
+
+var (
+	ErrBadUnit           = errors.New("weight unit must be kg or lb")
+	ErrBadPlateIncrement = errors.New("plate increment must be more than 0")
+)
+
+// Weight is a fixed-point weight in thousandths of a unit, so 1.25 kg plates
+// are exact. Stored weights are in kilograms, making them grams; ToUnit and
+// FromUnit convert them for a user. In JSON it is a decimal number.
+type Weight int64
+
+// Kilogram is one kilogram as a stored Weight.
+const Kilogram Weight = 1000
+
+// DefaultPlateIncrements are the plate increments of new users by unit: a
+// pair of the smallest common plates.
+var DefaultPlateIncrements = map[string]Weight{
+	UnitKg: 2500,
+	UnitLb: 5000,
+}
+
+// WeightOf returns the weight of a number of units, to the thousandth.
+func WeightOf(x float64) Weight {
+	return Weight(math.Round(x * 1000))
+}
+
+// ParseWeight reads a decimal weight, like "102.5".
+func ParseWeight(s string) (Weight, error) {
+	x, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+		return 0, fmt.Errorf("invalid weight %q", s)
+	}
+	return WeightOf(x), nil
+}
+
+// Float returns the weight as a number of units.
+func (w Weight) Float() float64 {
+	return float64(w) / 1000
+}
+
+// String formats the weight as a decimal number of units without trailing zeros.
+func (w Weight) String() string {
+	return strconv.FormatFloat(w.Float(), 'f', -1, 64)
+}
+
+func (w Weight) MarshalJSON() ([]byte, error) {
+	return []byte(w.String()), nil
+}
+
+func (w *Weight) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	var x float64
+	if err := json.Unmarshal(data, &x); err != nil {
+		return err
+	}
+	*w = WeightOf(x)
+	return nil
+}
+
+// ToUnit converts a stored weight to the unit. Pounds are rounded to the
+// hundredth, so a weight entered in pounds reads back as entered.
+func (w Weight) ToUnit(unit string) Weight {
+	if unit != UnitLb {
+		return w
+	}
+	return Weight(math.Round(float64(w)/kgPerLb/10) * 10)
+}
+
+// FromUnit converts a weight in the unit to a stored weight, to the gram.
+func (w Weight) FromUnit(unit string) Weight {
+	if unit != UnitLb {
+		return w
+	}
+	return Weight(math.Round(float64(w) * kgPerLb))
+}
+
+// Round rounds the weight to the nearest multiple of step, halves up. A step
+// of 0 leaves it as it is.
+func (w Weight) Round(step Weight) Weight {
+	if step <= 0 {
+		return w
+	}
+	return (w + step/2) / step * step
+}
+
+// RoundWeight rounds a stored weight to one the user can load: the nearest
+// multiple of their plate increment, in their unit.
+func (u UserDB) RoundWeight(w Weight) Weight {
+	return w.ToUnit(u.Unit).Round(u.PlateIncrement).FromUnit(u.Unit)
+}
+
+// unitOf reads a weight unit as the exports spell it.
+func unitOf(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "kg", "kgs":
+		return UnitKg, nil
+	case "lb", "lbs":
+		return UnitLb, nil
+	}
+	return "", ErrBadUnit
+}
+
+// PreferencesPatch is a partial update of the preferences of a user.
+type PreferencesPatch struct {
+	Unit           *string `json:"unit,omitempty"`
+	PlateIncrement *Weight `json:"plateIncrement,omitempty"` // in the unit, the one patched or else the current one
+}
+
+// UpdatePreferences applies a patch to the preferences of a user and returns
+// the user. Changing the unit without giving a plate increment resets it to
+// the default of the new unit.
+func UpdatePreferences(db Conn, userID uint64, patch PreferencesPatch) (UserDB, error) {
+	var user UserDB
+	err := db.Collection("users").Find(userID).One(&user)
+	if err == up.ErrNoMoreRows {
+		return UserDB{}, ErrNotFound
+	}
+	if err != nil {
+		return UserDB{}, err
+	}
+	if patch.Unit != nil {
+		unit, err := unitOf(*patch.Unit)
+		if err != nil {
+			return UserDB{}, err
+		}
+		if unit != user.Unit {
+			user.Unit, user.PlateIncrement = unit, DefaultPlateIncrements[unit]
+		}
+	}
+	if patch.PlateIncrement != nil {
+		if *patch.PlateIncrement <= 0 {
+			return UserDB{}, ErrBadPlateIncrement
+		}
+		user.PlateIncrement = *patch.PlateIncrement
+	}
+	err = db.Collection("users").Find(userID).Update(user)
+	return user, err
+}
+
+// unitOfUser returns the unit the user sees weights in.
+func unitOfUser(db Conn, userID uint64) (string, error) {
+	var user UserDB
+	err := db.Collection("users").Find(userID).One(&user)
+	if err == up.ErrNoMoreRows {
+		return "", ErrNotFound
+	}
+	return user.Unit, err
+}
+
+// ToUnit returns the set with its weights converted to the unit.
+func (s SetDB) ToUnit(unit string) SetDB {
+	s.Weight, s.WeightExpected = s.Weight.ToUnit(unit), s.WeightExpected.ToUnit(unit)
+	return s
+}
+
+// FromUnit returns the set, with weights given in the unit, with its weights as stored.
+func (s SetDB) FromUnit(unit string) SetDB {
+	s.Weight, s.WeightExpected = s.Weight.FromUnit(unit), s.WeightExpected.FromUnit(unit)
+	return s
+}
+
+// ToUnit returns the exercise with the weights of its sets converted to the unit.
+func (e Exercise) ToUnit(unit string) Exercise {
+	sets := make([]SetDB, len(e.Sets))
+	for i, s := range e.Sets {
+		sets[i] = s.ToUnit(unit)
+	}
+	e.Sets = sets
+	return e
+}
+
+// ToUnit returns the workout with the weights of its sets converted to the unit.
+func (w Workout) ToUnit(unit string) Workout {
+	exercises := make([]Exercise, len(w.Exercises))
+	for i, e := range w.Exercises {
+		exercises[i] = e.ToUnit(unit)
+	}
+	w.Exercises = exercises
+	return w
+}
+
+// ToUnit returns the catalog exercise with its increment converted to the unit.
+func (d ExerciseDefinitionDB) ToUnit(unit string) ExerciseDefinitionDB {
+	d.Increment = d.Increment.ToUnit(unit)
+	return d
+}
+
+// ToUnit returns the record with its weights converted to the unit.
+func (r Record) ToUnit(unit string) Record {
+	r.Weight = r.Weight.ToUnit(unit)
+	switch r.Kind {
+	case RecordWeight, RecordEpley, RecordBrzycki:
+		r.Value = convertFloat(r.Value, unit)
+	}
+	return r
+}
+
+// ToUnit returns the history with the weights of its sets converted to the unit.
+func (h ExerciseHistory) ToUnit(unit string) ExerciseHistory {
+	sessions := make([]ExerciseSession, len(h.Sessions))
+	for i, s := range h.Sessions {
+		sets := make([]SetDB, len(s.Sets))
+		for j, set := range s.Sets {
+			sets[j] = set.ToUnit(unit)
+		}
+		s.Sets = sets
+		sessions[i] = s
+	}
+	h.Definition, h.Sessions = h.Definition.ToUnit(unit), sessions
+	for _, set := range []**HistorySet{&h.Best, &h.Latest} {
+		if *set != nil {
+			converted := **set
+			converted.SetDB = converted.SetDB.ToUnit(unit)
+			*set = &converted
+		}
+	}
+	return h
+}
+
+// FromUnit returns the patch, with weights given in the unit, with its weights as stored.
+func (p SetPatch) FromUnit(unit string) SetPatch {
+	for _, w := range []**Weight{&p.Weight, &p.WeightExpected} {
+		if *w != nil {
+			stored := (**w).FromUnit(unit)
+			*w = &stored
+		}
+	}
+	return p
+}
+
+// FromUnit returns the patch, with the increment given in the unit, with the increment as stored.
+func (p ProgressionPatch) FromUnit(unit string) ProgressionPatch {
+	if p.Increment != nil {
+		stored := p.Increment.FromUnit(unit)
+		p.Increment = &stored
+	}
+	return p
+}
+
+// convertFloat converts a number of kilograms, like an estimated one-rep max,
+// to the unit, to the hundredth.
+func convertFloat(kg float64, unit string) float64 {
+	if unit != UnitLb {
+		return kg
+	}
+	return round2(kg / kgPerLb)
+}
@@ -0,0 +1,66 @@
+package store
+
+import "testing"
+
+func TestWeightRound(t *testing.T) {
+	for _, c := range []struct {
+		w, step, want Weight
+	}{
+		{101000, 2500, 100000},
+		{101300, 2500, 102500},
+		{101250, 2500, 102500}, // halves round up
+		{101249, 2500, 100000},
+		{102500, 2500, 102500},
+		{0, 2500, 0},
+		{222000, 5000, 220000},
+		{222500, 5000, 225000}, // halves round up
+		{227499, 5000, 225000},
+		{101234, 0, 101234}, // no step
+		{101234, -2500, 101234},
+	} {
+		if got := c.w.Round(c.step); got != c.want {
+			t.Errorf("Weight(%d).Round(%d) = %d, want %d", c.w, c.step, got, c.want)
+		}
+	}
+}
+
+func TestRoundWeight(t *testing.T) {
+	kg := UserDB{Unit: UnitKg, PlateIncrement: 2500}
+	lb := UserDB{Unit: UnitLb, PlateIncrement: 5000}
+	unset := UserDB{Unit: UnitKg}
+	for _, c := range []struct {
+		user   UserDB
+		stored Weight
+		want   Weight // in the unit of the user
+	}{
+		{kg, 61000, 60000},
+		{kg, 61250, 62500}, // halves round up
+		{kg, 0, 0},
+		{lb, 100000, 220000},                    // 220.46 lb
+		{lb, WeightOf(222.5 * kgPerLb), 225000}, // halves round up
+		{lb, WeightOf(102.058), 225000},         // 225 lb as stored
+		{unset, 61234, 61234},
+	} {
+		got := c.user.RoundWeight(c.stored)
+		if got.ToUnit(c.user.Unit) != c.want {
+			t.Errorf("RoundWeight(%d) for %s in steps of %d = %d, %s %d, want %s %d",
+				c.stored, c.user.Unit, c.user.PlateIncrement, got, c.user.Unit, got.ToUnit(c.user.Unit), c.user.Unit, c.want)
+		}
+		if again := c.user.RoundWeight(got); again != got {
+			t.Errorf("RoundWeight(%d) for %s isn't stable: %d, then %d", c.stored, c.user.Unit, got, again)
+		}
+	}
+}
+
+// Weights entered in pounds are stored in kilograms to the gram, and must
+// read back as entered, at the hundredth of a pound they are shown to.
+func TestPoundsRoundTrip(t *testing.T) {
+	for lb := Weight(0); lb <= 1000000; lb += 10 {
+		if got := lb.FromUnit(UnitLb).ToUnit(UnitLb); got != lb {
+			t.Fatalf("%s lb is stored as %s kg and reads back as %s lb", lb, lb.FromUnit(UnitLb), got)
+		}
+	}
+	if got := Weight(102500).ToUnit(UnitKg).FromUnit(UnitKg); got != 102500 {
+		t.Errorf("kilograms converted to kilograms: got %s", got)
+	}
+}
@@ -0,0 +1,372 @@
+package store
+
+import (
+	"errors"
+	"strings"
+
+	up "upper.io/db.v3"
+	"upper.io/db.v3/lib/sqlbuilder"
+)
+
+var (
+	ErrBadWorkoutName  = errors.New("workout name must not be empty")
+	ErrBadTimes        = errors.New("a workout cannot end before it starts")
+	ErrTemplateSession = errors.New("a template cannot become a session or a session a template")
+	ErrNegativeSet     = errors.New("set values must not be negative")
+	ErrTemplateActuals = errors.New("sets of a template only have expected values")
+	ErrWorkoutFinished = errors.New("the workout is finished and can no longer be changed")
+)
+
+// WorkoutPatch is a partial update of a workout. Nil fields are left as they are.
+type WorkoutPatch struct {
+	Name      *string `json:"name,omitempty"`
+	StartTime *uint64 `json:"startTime,omitempty"`
+	EndTime   *uint64 `json:"endTime,omitempty"`
+}
+
+// ExercisePatch is a partial update of an exercise in a workout.
+type ExercisePatch struct {
+	Definition *uint64 `json:"definition,omitempty"`
+	Notes      *string `json:"notes,omitempty"`
+}
+
+// SetPatch is a partial update of a set, actual and expected values alike.
+type SetPatch struct {
+	Order            *int    `json:"order,omitempty"`
+	Type             *string `json:"type,omitempty"`
+	Parent           *uint64 `json:"parent,omitempty"`
+	Reps             *int    `json:"reps,omitempty"`
+	Weight           *Weight `json:"weight,omitempty"`
+	Duration         *int    `json:"duration,omitempty"`
+	Rest             *int    `json:"rest,omitempty"`
+	RepsExpected     *int    `json:"repsExpected,omitempty"`
+	WeightExpected   *Weight `json:"weightExpected,omitempty"`
+	DurationExpected *int    `json:"durationExpected,omitempty"`
+	RestExpected     *int    `json:"restExpected,omitempty"`
+}
+
+// editableWorkout returns the user's workout unless it is finished. A
+// finished workout is the record of what was done, so it is read-only.
+func editableWorkout(db Conn, userID, workoutID uint64) (WorkoutDB, error) {
+	workout, err := UserWorkout(db, userID, workoutID)
+	if err != nil {
+		return WorkoutDB{}, err
+	}
+	if workout.EndTime != 0 {
+		return WorkoutDB{}, ErrWorkoutFinished
+	}
+	return workout, nil
+}
+
+// validWorkout checks the invariants of a workout about to be saved.
+func validWorkout(w WorkoutDB) error {
+	if w.Name == "" {
+		return ErrBadWorkoutName
+	}
+	if w.IsTemplate() && w.EndTime != 0 {
+		return ErrFinishTemplate
+	}
+	if w.EndTime != 0 && w.EndTime < w.StartTime {
+		return ErrBadTimes
+	}
+	return nil
+}
+
+// validSet checks the invariants of a set about to be saved in a workout.
+func validSet(s SetDB, workout WorkoutDB) error {
+	for _, v := range []int{s.Order, s.Reps, s.Duration, s.Rest,
+		s.RepsExpected, s.DurationExpected, s.RestExpected} {
+		if v < 0 {
+			return ErrNegativeSet
+		}
+	}
+	if s.Weight < 0 || s.WeightExpected < 0 {
+		return ErrNegativeSet
+	}
+	if workout.IsTemplate() && (s.Reps != 0 || s.Weight != 0 || s.Duration != 0 || s.Rest != 0) {
+		return ErrTemplateActuals
+	}
+	return nil
+}
+
+// AddWorkout adds a workout for the user: a template if it has no start time.
+func AddWorkout(db Conn, userID uint64, workout WorkoutDB) (WorkoutDB, error) {
+	workout.ID = 0
+	workout.User = userID
+	workout.Name = strings.TrimSpace(workout.Name)
+	if err := validWorkout(workout); err != nil {
+		return WorkoutDB{}, err
+	}
+	err := db.Collection("workouts").InsertReturning(&workout)
+	return workout, err
+}
+
+// UpdateWorkout applies a patch to one of the user's workouts and returns the result.
+func UpdateWorkout(db Conn, userID, workoutID uint64, patch WorkoutPatch) (WorkoutDB, error) {
+	workout, err := editableWorkout(db, userID, workoutID)
+	if err != nil {
+		return WorkoutDB{}, err
+	}
+	wasTemplate := workout.IsTemplate()
+	if patch.Name != nil {
+		workout.Name = strings.TrimSpace(*patch.Name)
+	}
+	if patch.StartTime != nil {
+		workout.StartTime = *patch.StartTime
+	}
+	if patch.EndTime != nil {
+		workout.EndTime = *patch.EndTime
+	}
+	if workout.IsTemplate() != wasTemplate {
+		return WorkoutDB{}, ErrTemplateSession
+	}
+	if err := validWorkout(workout); err != nil {
+		return WorkoutDB{}, err
+	}
+	err = db.Collection("workouts").Find(workout.ID).Update(workout)
+	return workout, err
+}
+
+// UserExercises returns the exercises of one of the user's workouts with their sets.
+func UserExercises(db Conn, userID, workoutID uint64) ([]Exercise, error) {
+	workout, err := LoadWorkout(db, userID, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	return workout.Exercises, nil
+}
+
+// UpdateExercise applies a patch to an exercise of one of the user's workouts.
+func UpdateExercise(db Conn, userID, exerciseID uint64, patch ExercisePatch) (ExerciseDB, error) {
+	exercise, err := UserExercise(db, userID, exerciseID)
+	if err != nil {
+		return ExerciseDB{}, err
+	}
+	if _, err := editableWorkout(db, userID, exercise.Workout); err != nil {
+		return ExerciseDB{}, err
+	}
+	if patch.Definition != nil {
+		exists, err := db.Collection("exercise_definitions").Find(*patch.Definition).Exists()
+		if err != nil {
+			return ExerciseDB{}, err
+		}
+		if !exists {
+			return ExerciseDB{}, ErrNotFound
+		}
+		exercise.Definition = *patch.Definition
+	}
+	if patch.Notes != nil {
+		exercise.Notes = *patch.Notes
+	}
+	err = db.Collection("workout_exercises").Find(exercise.ID).Update(exercise)
+	return exercise, err
+}
+
+// UserSets returns the sets of an exercise of one of the user's workouts by Order.
+func UserSets(db Conn, userID, exerciseID uint64) ([]SetDB, error) {
+	if _, err := UserExercise(db, userID, exerciseID); err != nil {
+		return nil, err
+	}
+	sets := []SetDB{}
+	err := db.Collection("sets").Find(up.Cond{"exercise": exerciseID}).OrderBy("order").All(&sets)
+	return sets, err
+}
+
+// AddSet appends a set to an exercise of one of the user's workouts. The
+// set's Order is ignored: it goes after the exercise's existing sets. A set
+// without a type is a working set.
+func AddSet(db sqlbuilder.Database, userID, exerciseID uint64, set SetDB) (SetDB, error) {
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		exercise, err := UserExercise(tx, userID, exerciseID)
+		if err != nil {
+			return err
+		}
+		workout, err := editableWorkout(tx, userID, exercise.Workout)
+		if err != nil {
+			return err
+		}
+		var last SetDB
+		err = tx.Collection("sets").Find(up.Cond{"exercise": exerciseID}).OrderBy("-order").One(&last)
+		switch err {
+		case nil:
+			set.Order = last.Order + 1
+		case up.ErrNoMoreRows:
+			set.Order = 0
+		default:
+			return err
+		}
+		set.ID = 0
+		set.Exercise = exerciseID
+		set.StartedAt, set.CompletedAt = 0, 0
+		if set.Type == "" {
+			set.Type = SetWorking
+		}
+		if err := validSet(set, workout); err != nil {
+			return err
+		}
+		if err := validSetType(tx, set); err != nil {
+			return err
+		}
+		if err := tx.Collection("sets").InsertReturning(&set); err != nil {
+			return err
+		}
+		set, err = withRecords(tx, workout, exercise, set)
+		return err
+	})
+	if err != nil {
+		return SetDB{}, err
+	}
+	return set, nil
+}
+
+// UpdateSet applies a patch to a set of one of the user's workouts and returns
+// the result, with the personal records it now holds. Changing the type of a
+// set to one without a rep target clears its expected reps, and from a drop
+// set its parent, unless the patch gives them. Moving a set to another order
+// shifts the sets in between to keep the orders of the exercise distinct, so
+// run it in a transaction.
+func UpdateSet(db Conn, userID, setID uint64, patch SetPatch) (SetDB, error) {
+	set, err := UserSet(db, userID, setID)
+	if err != nil {
+		return SetDB{}, err
+	}
+	exercise, err := UserExercise(db, userID, set.Exercise)
+	if err != nil {
+		return SetDB{}, err
+	}
+	workout, err := editableWorkout(db, userID, exercise.Workout)
+	if err != nil {
+		return SetDB{}, err
+	}
+	order := set.Order
+	for _, f := range []struct {
+		patch *int
+		field *int
+	}{
+		{patch.Order, &set.Order},
+		{patch.Reps, &set.Reps},
+		{patch.Duration, &set.Duration},
+		{patch.Rest, &set.Rest},
+		{patch.RepsExpected, &set.RepsExpected},
+		{patch.DurationExpected, &set.DurationExpected},
+		{patch.RestExpected, &set.RestExpected},
+	} {
+		if f.patch != nil {
+			*f.field = *f.patch
+		}
+	}
+	for _, f := range []struct {
+		patch *Weight
+		field *Weight
+	}{
+		{patch.Weight, &set.Weight},
+		{patch.WeightExpected, &set.WeightExpected},
+	} {
+		if f.patch != nil {
+			*f.field = *f.patch
+		}
+	}
+	if patch.Type != nil {
+		set.Type = *patch.Type
+		if !set.HasRepTarget() && patch.RepsExpected == nil {
+			set.RepsExpected = 0
+		}
+		if set.Type != SetDrop && patch.Parent == nil {
+			set.Parent = 0
+		}
+	}
+	if patch.Parent != nil {
+		set.Parent = *patch.Parent
+	}
+	if err := validSet(set, workout); err != nil {
+		return SetDB{}, err
+	}
+	if err := validSetType(db, set); err != nil {
+		return SetDB{}, err
+	}
+	if err := moveSet(db, set, order); err != nil {
+		return SetDB{}, err
+	}
+	if err := db.Collection("sets").Find(set.ID).Update(set); err != nil {
+		return SetDB{}, err
+	}
+	return withRecords(db, workout, exercise, set)
+}
+
+// moveSet makes room for a set moving from order from to set.Order by
+// shifting the other sets of its exercise between the two by one, toward the
+// place it leaves.
+func moveSet(db Conn, set SetDB, from int) error {
+	if set.Order == from {
+		return nil
+	}
+	cond, shift := up.Cond{"order >": from, "order <=": set.Order}, -1
+	if set.Order < from {
+		cond, shift = up.Cond{"order >=": set.Order, "order <": from}, 1
+	}
+	cond["exercise"] = set.Exercise
+	cond["id !="] = set.ID
+	var others []SetDB
+	if err := db.Collection("sets").Find(cond).All(&others); err != nil {
+		return err
+	}
+	for _, other := range others {
+		err := db.Collection("sets").Find(other.ID).Update(up.Cond{"order": other.Order + shift})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveExercise removes an exercise, and its sets, from one of the user's
+// workouts and returns what was removed.
+func RemoveExercise(db sqlbuilder.Database, userID, exerciseID uint64) (ExerciseDB, error) {
+	var exercise ExerciseDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		var err error
+		if exercise, err = UserExercise(tx, userID, exerciseID); err != nil {
+			return err
+		}
+		if _, err := editableWorkout(tx, userID, exercise.Workout); err != nil {
+			return err
+		}
+		return tx.Collection("workout_exercises").Find(exerciseID).Delete()
+	})
+	if err != nil {
+		return ExerciseDB{}, err
+	}
+	return exercise, nil
+}
+
+// RemoveSet removes a set from an exercise of one of the user's workouts
+// and returns what was removed. A set with drop sets can't be removed
+// before them.
+func RemoveSet(db sqlbuilder.Database, userID, setID uint64) (SetDB, error) {
+	var set SetDB
+	err := db.Tx(db.Context(), func(tx sqlbuilder.Tx) error {
+		var err error
+		if set, err = UserSet(tx, userID, setID); err != nil {
+			return err
+		}
+		exercise, err := UserExercise(tx, userID, set.Exercise)
+		if err != nil {
+			return err
+		}
+		if _, err := editableWorkout(tx, userID, exercise.Workout); err != nil {
+			return err
+		}
+		hasDrops, err := tx.Collection("sets").Find(up.Cond{"parent": setID}).Exists()
+		if err != nil {
+			return err
+		}
+		if hasDrops {
+			return ErrHasDropSets
+		}
+		return tx.Collection("sets").Find(setID).Delete()
+	})
+	if err != nil {
+		return SetDB{}, err
+	}
+	return set, nil
+}